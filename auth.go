@@ -0,0 +1,199 @@
+package cheerio
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials for a package index. If Token is set, it is sent as a bearer token; otherwise Username and Password are sent with basic auth.
+// Credentials format as "[redacted]", so they don't leak into logs or error messages.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (c Credentials) String() string   { return "[redacted]" }
+func (c Credentials) GoString() string { return "[redacted]" }
+
+// Environment variables that hold credentials for a private package index. They are only used for an index with EnvCredentials set, or for the
+// index on the host named by CHEERIO_INDEX_HOST (e.g., "pypi.example.com" or "pypi.example.com:8443") if it is set, and never for public PyPI.
+const (
+	EnvIndexUsername = "CHEERIO_INDEX_USERNAME"
+	EnvIndexPassword = "CHEERIO_INDEX_PASSWORD"
+	EnvIndexToken    = "CHEERIO_INDEX_TOKEN"
+	EnvIndexHost     = "CHEERIO_INDEX_HOST"
+)
+
+var publicPyPIHosts = []string{"pypi.python.org", "pypi.org", "files.pythonhosted.org"}
+
+// Returns the credentials to use for the index, or nil if there are none. In order of precedence, they are taken from the Credentials field,
+// the userinfo of the index URI, the ~/.netrc entry for the index host (or the file named by $NETRC), and the CHEERIO_INDEX_* environment
+// variables, if they apply to the index (see EnvIndexHost).
+func (p *PackageIndex) credentials() *Credentials {
+	p.credsOnce.Do(func() {
+		if p.Credentials != nil {
+			p.creds = p.Credentials
+			return
+		} else if _, isLocal := p.localDir(); isLocal {
+			return
+		}
+		u, err := url.Parse(p.URI)
+		if err != nil {
+			return
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			p.creds = &Credentials{Username: u.User.Username(), Password: password}
+		} else if creds := netrcCredentials(u.Hostname(), !isPublicPyPI(u.Hostname())); creds != nil {
+			p.creds = creds
+		} else if !isPublicPyPI(u.Hostname()) && envCredentialsApply(u, p.EnvCredentials) {
+			p.creds = envCredentials()
+		}
+	})
+	return p.creds
+}
+
+// Adds the index credentials to a request, if it is addressed to the index host. Files served from other hosts never see them.
+func (p *PackageIndex) authorize(req *http.Request) {
+	creds := p.credentials()
+	if creds == nil {
+		return
+	}
+	u, err := url.Parse(p.URI)
+	if err != nil || !strings.EqualFold(req.URL.Host, u.Host) {
+		return
+	}
+	if creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+	} else {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+}
+
+// Returns the index URI without any credentials, so that indexes can be logged safely.
+func (p *PackageIndex) String() string {
+	return p.baseURL()
+}
+
+// Returns the index URI without any credentials or trailing slash. Request URLs and messages are built from this, never from URI. Local
+// indexes are given as a file:// URL.
+func (p *PackageIndex) baseURL() string {
+	if dir, isLocal := p.localDir(); isLocal {
+		return strings.TrimSuffix(fileURL(dir), "/")
+	}
+	return strings.TrimSuffix(redactURL(p.URI), "/")
+}
+
+// Removes the userinfo from a URL.
+func redactURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+	u.User = nil
+	return u.String()
+}
+
+func isPublicPyPI(host string) bool {
+	for _, public := range publicPyPIHosts {
+		if strings.EqualFold(host, public) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the CHEERIO_INDEX_* credentials are meant for an index: the one on the host named by CHEERIO_INDEX_HOST if it is set, and
+// otherwise any index that asks for them.
+func envCredentialsApply(u *url.URL, asked bool) bool {
+	host := os.Getenv(EnvIndexHost)
+	if host == "" {
+		return asked
+	}
+	return strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname())
+}
+
+func envCredentials() *Credentials {
+	creds := &Credentials{
+		Username: os.Getenv(EnvIndexUsername),
+		Password: os.Getenv(EnvIndexPassword),
+		Token:    os.Getenv(EnvIndexToken),
+	}
+	if creds.Token == "" && creds.Username == "" {
+		return nil
+	}
+	return creds
+}
+
+// Looks up the login and password for a host in the netrc file. The "default" entry is used only if useDefault is set. Returns nil if the file or
+// a matching entry doesn't exist.
+func netrcCredentials(host string, useDefault bool) *Credentials {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	return parseNetrc(tokens, host, useDefault)
+}
+
+// Finds the entry for a host among the whitespace-separated tokens of a netrc file.
+func parseNetrc(tokens []string, host string, useDefault bool) *Credentials {
+	var current, fallback *Credentials
+	var currentMatches bool
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if currentMatches {
+				return current
+			}
+			current = &Credentials{}
+			currentMatches = i+1 < len(tokens) && strings.EqualFold(tokens[i+1], host)
+			i++
+		case "default":
+			if currentMatches {
+				return current
+			}
+			current = &Credentials{}
+			currentMatches = false
+			fallback = current
+		case "login", "password", "account":
+			if i+1 < len(tokens) && current != nil {
+				if tokens[i] == "login" {
+					current.Username = tokens[i+1]
+				} else if tokens[i] == "password" {
+					current.Password = tokens[i+1]
+				}
+			}
+			i++
+		case "macdef":
+			// Macro definitions run to the end of the entry; skip the name and let the next machine or default token resume parsing
+			i++
+		}
+	}
+	if currentMatches {
+		return current
+	} else if useDefault {
+		return fallback
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cheerio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPackageIndexAuth(t *testing.T) {
+	var gotUser, gotPassword string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPassword, _ = r.BasicAuth()
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	index := &PackageIndex{URI: strings.Replace(server.URL, "http://", "http://alice:s3cret@", 1)}
+	_, err := index.Releases("foo")
+	if err == nil {
+		t.Fatal("want error for forbidden response")
+	}
+	if gotUser != "alice" || gotPassword != "s3cret" {
+		t.Errorf("want basic auth alice:s3cret, got %s:%s", gotUser, gotPassword)
+	}
+	if strings.Contains(err.Error(), "s3cret") || strings.Contains(index.String(), "s3cret") {
+		t.Errorf("credentials leaked: %q, %q", err, index)
+	}
+
+	index = &PackageIndex{URI: server.URL, Credentials: &Credentials{Token: "t0ken"}}
+	req, _ := http.NewRequest("GET", "https://files.example.com/foo-1.0.tar.gz", nil)
+	index.authorize(req)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("credentials sent to another host: %q", auth)
+	}
+	req, _ = http.NewRequest("GET", server.URL+"/packages/foo-1.0.tar.gz", nil)
+	index.authorize(req)
+	if auth := req.Header.Get("Authorization"); auth != "Bearer t0ken" {
+		t.Errorf("want bearer token, got %q", auth)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	tokens := strings.Fields(`
+machine pypi.internal.example.com login bob password hunter2
+default login anon password anon`)
+
+	if creds := parseNetrc(tokens, "pypi.internal.example.com", true); creds == nil || creds.Username != "bob" || creds.Password != "hunter2" {
+		t.Errorf("want bob:hunter2, got %#v", creds)
+	}
+	if creds := parseNetrc(tokens, "other.example.com", true); creds == nil || creds.Username != "anon" {
+		t.Errorf("want default entry, got %#v", creds)
+	}
+	if creds := parseNetrc(tokens, "pypi.org", false); creds != nil {
+		t.Errorf("want no credentials, got %+v", *creds)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv(EnvIndexUsername, "carol")
+	t.Setenv(EnvIndexHost, "")
+	t.Setenv("NETRC", os.DevNull)
+
+	primary := &PackageIndex{URI: "https://pypi.example.com", EnvCredentials: true}
+	extra := &PackageIndex{URI: "https://extra.example.com"}
+	set := NewIndexSet(primary, extra)
+	if creds := set.Indexes[0].credentials(); creds == nil || creds.Username != "carol" {
+		t.Errorf("want the primary index to use the environment credentials, got %v", creds)
+	}
+	if creds := set.Indexes[1].credentials(); creds != nil {
+		t.Errorf("environment credentials sent to an extra index")
+	}
+
+	// CHEERIO_INDEX_HOST picks the index they belong to instead
+	t.Setenv(EnvIndexHost, "Extra.example.com")
+	primary = &PackageIndex{URI: "https://pypi.example.com", EnvCredentials: true}
+	extra = &PackageIndex{URI: "https://extra.example.com"}
+	if creds := primary.credentials(); creds != nil {
+		t.Errorf("environment credentials sent to a host other than %s", os.Getenv(EnvIndexHost))
+	}
+	if creds := extra.credentials(); creds == nil || creds.Username != "carol" {
+		t.Errorf("want the index on %s to use the environment credentials, got %v", os.Getenv(EnvIndexHost), creds)
+	}
+	if creds := (&PackageIndex{URI: "https://pypi.org", EnvCredentials: true}).credentials(); creds != nil {
+		t.Errorf("environment credentials sent to public PyPI")
+	}
+}
@@ -0,0 +1,152 @@
+package cheerio
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// The metadata files of the latest release of a package, read from a single download of its archive. A file is nil if the archive doesn't
+// contain it. If the archive contains several (e.g., because it vendors other packages), the package's own is chosen; all of them are in
+// Files.
+type MetadataBundle struct {
+	Package string
+	File    *DistFile   // the archive the files were read from
+	Files   fetch.Files // every metadata file found in the archive, keyed by path
+
+	PKGInfo     []byte
+	RequiresTxt []byte
+	TopLevelTxt []byte
+	EntryPoints []byte // entry_points.txt
+	SourcesTxt  []byte // SOURCES.txt
+
+	// The code hosts SourceRepoURL, SourceRepo and SourceRepoCandidates recognize: those of the index the bundle was fetched from. If nil,
+	// DefaultRepoHosts is used.
+	RepoHosts []*RepoHost
+}
+
+func eggInfoPattern(filename string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:[^/]+/)*(?:[^/]*\.egg\-info/` + regexp.QuoteMeta(filename) + `)`)
+}
+
+// Patterns for the files of a MetadataBundle, in the order of its fields, for tarballs and zips and for eggs.
+var bundleTarPatterns = []*regexp.Regexp{
+	pkgInfoPattern, requiresTxtTarPattern, topLevelTxtPattern, eggInfoPattern("entry_points.txt"), eggInfoPattern("SOURCES.txt"),
+}
+var bundleEggPatterns = []*regexp.Regexp{
+	pkgInfoPattern, requiresTxtEggPattern, regexp.MustCompile(`EGG\-INFO/top_level\.txt`), regexp.MustCompile(`EGG\-INFO/entry_points\.txt`),
+	regexp.MustCompile(`EGG\-INFO/SOURCES\.txt`),
+}
+
+// Patterns that match any of the files of a MetadataBundle, so they can be extracted together.
+var bundleTarPattern = anyPattern(bundleTarPatterns)
+var bundleEggPattern = anyPattern(bundleEggPatterns)
+
+func anyPattern(patterns []*regexp.Regexp) *regexp.Regexp {
+	var alternatives []string
+	for _, pattern := range patterns {
+		alternatives = append(alternatives, "(?:"+pattern.String()+")")
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|"))
+}
+
+// Downloads the latest archive of a package once and returns all of its metadata files. Use it rather than FetchPackageRequirements,
+// FetchSourceRepoURL and FetchSourceTopLevelModules when more than one of them is needed.
+func (p *PackageIndex) FetchMetadataBundle(pkg string) (*MetadataBundle, error) {
+	return p.FetchMetadataBundleContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchMetadataBundleContext(ctx context.Context, pkg string) (*MetadataBundle, error) {
+	files, err := p.pkgFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	return fetchLatestMetadataBundle(ctx, pkg, files, p.repoHosts())
+}
+
+func fetchLatestMetadataBundle(ctx context.Context, pkg string, files []*DistFile, hosts []*RepoHost) (*MetadataBundle, error) {
+	file, kind, err := latestMetadataArchive(pkg, files)
+	if err != nil {
+		return nil, err
+	}
+	patterns, pattern := bundleTarPatterns, bundleTarPattern
+	if kind == eggArchive {
+		patterns, pattern = bundleEggPatterns, bundleEggPattern
+	}
+	metadata, err := file.extract(ctx, pattern, kind.compressionType())
+	if err != nil {
+		return nil, err
+	}
+
+	var data [5][]byte
+	for i, pattern := range patterns {
+		data[i] = topLevelFile(pkg, matchingFiles(metadata, pattern))
+	}
+	return &MetadataBundle{
+		Package:     pkg,
+		File:        file,
+		Files:       metadata,
+		PKGInfo:     data[0],
+		RequiresTxt: data[1],
+		TopLevelTxt: data[2],
+		EntryPoints: data[3],
+		SourcesTxt:  data[4],
+		RepoHosts:   hosts,
+	}, nil
+}
+
+// Returns the requirements listed in requires.txt, as FetchPackageRequirements does. Returns a *fetch.NoMatchError if there is no
+// requires.txt.
+func (b *MetadataBundle) Requirements() ([]*Requirement, error) {
+	if b.RequiresTxt == nil {
+		return nil, &fetch.NoMatchError{Pattern: requiresTxtTarPattern}
+	}
+	return ParseRequirements(string(b.RequiresTxt))
+}
+
+// Returns the top-level modules listed in top_level.txt, as FetchSourceTopLevelModules does.
+func (b *MetadataBundle) TopLevelModules() ([]string, error) {
+	if b.TopLevelTxt == nil {
+		if hardCodedModules, in := pypiTopLevelModules[b.Package]; in {
+			return hardCodedModules, nil
+		}
+		return nil, &fetch.NoMatchError{Pattern: topLevelTxtPattern}
+	}
+	return parseTopLevelTxt(b.TopLevelTxt), nil
+}
+
+// Returns the source repository URL inferred from PKG-INFO, as FetchSourceRepoURL does.
+func (b *MetadataBundle) SourceRepoURL() (string, error) {
+	if b.PKGInfo == nil {
+		if hardURL, in := pypiRepos[NormalizedPkgName(b.Package)]; in {
+			return hardURL, nil
+		}
+		return "", &fetch.NoMatchError{Pattern: pkgInfoPattern}
+	}
+	return sourceRepoURL(b.Package, b.PKGInfo, repoHostsOrDefault(b.RepoHosts))
+}
+
+// Returns the source repository inferred from PKG-INFO, as FetchSourceRepo does.
+func (b *MetadataBundle) SourceRepo() (*RepoRef, error) {
+	if b.PKGInfo == nil {
+		if hardURL, in := pypiRepos[NormalizedPkgName(b.Package)]; in {
+			return ParseRepoRefWithHosts(hardURL, repoHostsOrDefault(b.RepoHosts))
+		}
+		return nil, &fetch.NoMatchError{Pattern: pkgInfoPattern}
+	}
+	return sourceRepo(b.Package, b.PKGInfo, repoHostsOrDefault(b.RepoHosts))
+}
+
+// Returns the candidate source repositories inferred from PKG-INFO, as FetchSourceRepoCandidates does.
+func (b *MetadataBundle) SourceRepoCandidates() ([]*RepoCandidate, error) {
+	if b.PKGInfo == nil {
+		if candidates := rankRepoCandidates(hardcodedRepoEvidence(b.Package), repoHostsOrDefault(b.RepoHosts)); len(candidates) > 0 {
+			return candidates, nil
+		}
+		return nil, &fetch.NoMatchError{Pattern: pkgInfoPattern}
+	}
+	candidates, _, err := sourceRepoCandidates(b.Package, b.PKGInfo, repoHostsOrDefault(b.RepoHosts))
+	return candidates, err
+}
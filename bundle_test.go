@@ -0,0 +1,120 @@
+package cheerio
+
+import (
+	"testing"
+
+	"github.com/beyang/cheerio/fetch"
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestFetchMetadataBundle(t *testing.T) {
+	server := pypitest.NewServer(
+		&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{
+			Version:     "1.0",
+			PKGInfo:     "Metadata-Version: 1.0\nName: foo\nVersion: 1.0\nHome-page: https://github.com/example/foo\n",
+			RequiresTxt: "bar>=2.0\n",
+			TopLevelTxt: "foo\n",
+			ExtraFiles: map[string]string{
+				"foo.egg-info/entry_points.txt": "[console_scripts]\nfoo = foo:main\n",
+				"foo.egg-info/SOURCES.txt":      "setup.py\nfoo/__init__.py\n",
+			},
+		}}},
+		&pypitest.Project{Name: "baz", Releases: []*pypitest.Release{{
+			Version:     "0.1",
+			TopLevelTxt: "baz\n",
+			ExtraFiles:  map[string]string{"EGG-INFO/entry_points.txt": "[console_scripts]\nbaz = baz:main\n"},
+			Formats:     []pypitest.Format{pypitest.Egg},
+		}}},
+	)
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	bundle, err := index.FetchMetadataBundle("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Requests("/packages/" + pypitest.Filename("foo", "1.0", pypitest.Sdist)); got != 1 {
+		t.Errorf("want the archive downloaded once, got %d downloads", got)
+	}
+	if reqs, err := bundle.Requirements(); err != nil || len(reqs) != 1 || reqs[0].Name != "bar" {
+		t.Errorf("want requirement bar, got %+v (error: %v)", reqs, err)
+	}
+	if modules, err := bundle.TopLevelModules(); err != nil || len(modules) != 1 || modules[0] != "foo" {
+		t.Errorf("want top-level module foo, got %v (error: %v)", modules, err)
+	}
+	if repoURL, err := bundle.SourceRepoURL(); err != nil || repoURL != "https://github.com/example/foo" {
+		t.Errorf("want repo https://github.com/example/foo, got %q (error: %v)", repoURL, err)
+	}
+	if string(bundle.EntryPoints) != "[console_scripts]\nfoo = foo:main\n" {
+		t.Errorf("unexpected entry_points.txt %q", bundle.EntryPoints)
+	}
+	if string(bundle.SourcesTxt) != "setup.py\nfoo/__init__.py\n" {
+		t.Errorf("unexpected SOURCES.txt %q", bundle.SourcesTxt)
+	}
+
+	// Eggs keep their metadata in EGG-INFO/
+	bundle, err = index.FetchMetadataBundle("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modules, err := bundle.TopLevelModules(); err != nil || len(modules) != 1 || modules[0] != "baz" {
+		t.Errorf("egg: want top-level module baz, got %v (error: %v)", modules, err)
+	}
+	if string(bundle.EntryPoints) != "[console_scripts]\nbaz = baz:main\n" {
+		t.Errorf("egg: unexpected entry_points.txt %q", bundle.EntryPoints)
+	}
+	if _, err := bundle.Requirements(); err == nil {
+		t.Errorf("egg: want *fetch.NoMatchError for missing requires.txt, got nil")
+	} else if _, ok := err.(*fetch.NoMatchError); !ok {
+		t.Errorf("egg: want *fetch.NoMatchError, got %v", err)
+	}
+}
+
+func TestVendoredMetadata(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{
+		Version:     "1.0",
+		RequiresTxt: "six\n",
+		TopLevelTxt: "foo\n",
+		ExtraFiles: map[string]string{
+			"vendor/bar-2.0/PKG-INFO":                   "Metadata-Version: 1.0\nName: bar\nVersion: 2.0\n",
+			"vendor/bar-2.0/bar.egg-info/requires.txt":  "requests\n",
+			"vendor/bar-2.0/bar.egg-info/top_level.txt": "bar\n",
+		},
+	}}})
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	reqs, err := index.FetchPackageRequirements("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 || reqs[0].Name != "six" {
+		t.Errorf("want only foo's own requirement six, got %+v", reqs)
+	}
+	modules, err := index.FetchSourceTopLevelModules("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 1 || modules[0] != "foo" {
+		t.Errorf("want only foo's own top-level module, got %v", modules)
+	}
+
+	bundle, err := index.FetchMetadataBundle("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bundle.RequiresTxt) != "six\n" {
+		t.Errorf("bundle: want foo's own requires.txt, got %q", bundle.RequiresTxt)
+	}
+	if _, in := bundle.Files["foo-1.0/vendor/bar-2.0/bar.egg-info/requires.txt"]; !in {
+		t.Errorf("bundle: want vendored files kept in Files, got %d files", len(bundle.Files))
+	}
+
+	files, err := index.FetchMetadataFiles("foo", requiresTxtTarPattern, requiresTxtEggPattern, requiresTxtZipPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("FetchMetadataFiles: want 2 requires.txt files, got %d", len(files))
+	}
+}
@@ -0,0 +1,145 @@
+package cheerio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+
+	"github.com/beyang/cheerio/cache"
+	"github.com/beyang/cheerio/fetch"
+)
+
+// Caching is best-effort: errors writing to the cache are ignored, so a full or read-only cache directory only makes cheerio slower.
+
+// Returns the cache for requests to the index, or nil if the index isn't cached. Local indexes are never cached.
+func (p *PackageIndex) cache() *cache.Cache {
+	if _, isLocal := p.localDir(); isLocal {
+		return nil
+	}
+	return p.Cache
+}
+
+// Keeps index pages, archives and the metadata extracted from archives in a cache, and fetches what isn't there with another Fetcher. Pages are
+// used as they are while fresh (see cache.Cache.TTL), and revalidated once stale. Archives are stored by hash, so they are never downloaded
+// twice. Archives the index publishes no hash for aren't cached at all, nor is what is extracted from them, as there would be no telling
+// whether they had changed. A PackageIndex with a Cache uses one.
+type CachedFetcher struct {
+	Fetcher Fetcher
+	Cache   *cache.Cache
+
+	// Reads the cached archives. If nil, fetch.DefaultClient is used.
+	Client *fetch.Client
+}
+
+func (c *CachedFetcher) client() *fetch.Client {
+	if c.Client == nil {
+		return fetch.DefaultClient
+	}
+	return c.Client
+}
+
+func (c *CachedFetcher) FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error) {
+	key := pageCacheKey(uri, accept)
+	if cached, fresh := c.Cache.Page(key); cached != nil {
+		pg := pageFromCache(cached)
+		if fresh {
+			return pg, nil
+		}
+		stale = pg
+	}
+	pg, err := c.Fetcher.FetchPage(ctx, uri, accept, stale)
+	if err != nil {
+		return nil, err
+	}
+	c.Cache.PutPage(&cache.Page{
+		Key:          key,
+		URL:          pg.URL,
+		ContentType:  pg.ContentType,
+		Body:         pg.Body,
+		ETag:         pg.ETag,
+		LastModified: pg.LastModified,
+		Fetched:      pg.Fetched,
+	})
+	return pg, nil
+}
+
+// Downloads the archive into the cache (or reads it from there) to extract from it, and caches the extracted files too.
+func (c *CachedFetcher) ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	if !file.cacheable() {
+		return c.Fetcher.ExtractFiles(ctx, file, pattern, compressionType)
+	}
+	metadataKey := file.metadataKey(pattern)
+	if b, in := c.Cache.Metadata(metadataKey); in {
+		var files fetch.Files
+		if err := json.Unmarshal(b, &files); err == nil && files != nil {
+			return files, nil
+		}
+	}
+
+	algorithm, digest := file.cacheKey()
+	path, in := c.Cache.Blob(algorithm, digest, file.Filename)
+	if !in {
+		if _, err := c.Cache.PrepareBlob(algorithm, digest, file.Filename); err != nil {
+			return nil, err
+		}
+		if err := c.Fetcher.DownloadFile(ctx, file, path); err != nil {
+			return nil, err
+		}
+	}
+	files, err := c.client().ExtractFile(ctx, path, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(files); err == nil {
+		c.Cache.PutMetadata(metadataKey, b)
+	}
+	return files, nil
+}
+
+func (c *CachedFetcher) DownloadFile(ctx context.Context, file *DistFile, path string) error {
+	return c.Fetcher.DownloadFile(ctx, file, path)
+}
+
+func pageCacheKey(uri, accept string) string {
+	return accept + " " + uri
+}
+
+func pageFromCache(cached *cache.Page) *IndexPage {
+	return &IndexPage{
+		URL:          cached.URL,
+		ContentType:  cached.ContentType,
+		Body:         cached.Body,
+		ETag:         cached.ETag,
+		LastModified: cached.LastModified,
+		Fetched:      cached.Fetched,
+	}
+}
+
+// Reports whether a file, and what is extracted from it, may be cached: only if the index publishes a hash for it, which identifies its contents.
+func (f *DistFile) cacheable() bool {
+	algorithm, _ := strongestHash(f.Hashes)
+	return algorithm != ""
+}
+
+// Returns the key identifying a file: its strongest published hash or, if the index publishes none, a hash of its URL. Only the files that are
+// cacheable are cached under it, as the contents at a URL may change.
+func (f *DistFile) cacheKey() (algorithm, digest string) {
+	if algorithm, digest := strongestHash(f.Hashes); algorithm != "" {
+		return algorithm, digest
+	}
+	sum := sha256.Sum256([]byte(f.URL))
+	return "url", hex.EncodeToString(sum[:])
+}
+
+// Extracts the files that match pattern from the file's archive with the fetcher of its index. Concurrent and recent identical extractions are
+// shared (see memoizeMetadata).
+func (f *DistFile) extract(ctx context.Context, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	if f.Index == nil {
+		return f.fetcher().ExtractFiles(ctx, f, pattern, compressionType)
+	}
+	return f.Index.memoizeMetadata(ctx, f.metadataKey(pattern), f.cacheable(), func(ctx context.Context) (fetch.Files, error) {
+		return f.Index.fetcher().ExtractFiles(ctx, f, pattern, compressionType)
+	})
+}
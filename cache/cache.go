@@ -0,0 +1,241 @@
+// Package cache implements a persistent, content-addressed cache of package index pages, archives and the metadata extracted from them.
+//
+// The cache directory is laid out as:
+//
+//	pages/<sha256 of key>                 index pages, with the validators needed to revalidate them (JSON)
+//	blobs/<algorithm>/<digest>/<filename> archives, addressed by their hash
+//	metadata/<sha256 of key>              metadata extracted from archives
+//
+// Entries are written atomically, so several processes can share a cache. Reading an entry updates its modification time, which Prune uses
+// to find entries that haven't been used recently.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	pagesDir    = "pages"
+	blobsDir    = "blobs"
+	metadataDir = "metadata"
+)
+
+// A cache directory.
+type Cache struct {
+	Dir string
+
+	// How long a cached index page is used without revalidating it with the server. If zero, pages are always revalidated.
+	TTL time.Duration
+}
+
+func New(dir string, ttl time.Duration) *Cache {
+	return &Cache{Dir: dir, TTL: ttl}
+}
+
+// Returns the default cache directory, in the user's cache directory (e.g., ~/.cache/cheerio on Linux).
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "cheerio")
+}
+
+// A cached index page.
+type Page struct {
+	Key          string // identifies the request, e.g., its URL and Accept header
+	URL          string // the final URL of the page, after redirects
+	ContentType  string
+	Body         []byte
+	ETag         string
+	LastModified string
+	Fetched      time.Time // when the page was last fetched or revalidated
+}
+
+// Returns the cached page for a key, and whether it is fresh, i.e., was fetched less than TTL ago. Returns nil if the page isn't cached.
+func (c *Cache) Page(key string) (*Page, bool) {
+	path := c.path(pagesDir, hashKey(key))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var page Page
+	if err := json.Unmarshal(b, &page); err != nil || page.Key != key {
+		return nil, false
+	}
+	touch(path)
+	return &page, time.Since(page.Fetched) < c.TTL
+}
+
+func (c *Cache) PutPage(page *Page) error {
+	b, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(pagesDir, hashKey(page.Key)), func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// Returns the path of a cached archive with the given hash, and whether it exists.
+func (c *Cache) Blob(algorithm, digest, filename string) (string, bool) {
+	path := c.BlobPath(algorithm, digest, filename)
+	if _, err := os.Stat(path); err != nil {
+		return path, false
+	}
+	touch(path)
+	return path, true
+}
+
+// Returns the path where an archive with the given hash is stored. The archive keeps its filename, so its type can
+// still be told from its extension. Callers must write the path atomically and only after checking the hash, e.g., with fetch.Client.DownloadFile.
+func (c *Cache) BlobPath(algorithm, digest, filename string) string {
+	return c.path(blobsDir, filepath.Join(safeName(strings.ToLower(algorithm)), safeName(strings.ToLower(digest)), safeName(filename)))
+}
+
+// Creates the directory for an archive, so it can be written to BlobPath.
+func (c *Cache) PrepareBlob(algorithm, digest, filename string) (string, error) {
+	path := c.BlobPath(algorithm, digest, filename)
+	return path, os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// Returns cached metadata for a key (e.g., an archive hash plus the name of the file extracted from it).
+func (c *Cache) Metadata(key string) ([]byte, bool) {
+	path := c.path(metadataDir, hashKey(key))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	touch(path)
+	return b, true
+}
+
+func (c *Cache) PutMetadata(key string, data []byte) error {
+	return writeFileAtomic(c.path(metadataDir, hashKey(key)), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// The number and total size of the entries of each kind.
+type Stats struct {
+	Pages, Blobs, Metadata Count
+}
+
+type Count struct {
+	Entries int
+	Bytes   int64
+}
+
+func (c *Cache) Stats() (*Stats, error) {
+	stats := &Stats{}
+	for dir, count := range map[string]*Count{pagesDir: &stats.Pages, blobsDir: &stats.Blobs, metadataDir: &stats.Metadata} {
+		err := c.walk(dir, func(path string, info os.FileInfo) error {
+			count.Entries++
+			count.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// Removes entries that haven't been used for maxAge, and returns the number removed.
+func (c *Cache) Prune(maxAge time.Duration) (int, error) {
+	removed := 0
+	for _, dir := range []string{pagesDir, blobsDir, metadataDir} {
+		err := c.walk(dir, func(path string, info os.FileInfo) error {
+			if time.Since(info.ModTime()) > maxAge {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Removes every entry.
+func (c *Cache) Clear() error {
+	for _, dir := range []string{pagesDir, blobsDir, metadataDir} {
+		if err := os.RemoveAll(filepath.Join(c.Dir, dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls fn for each entry in one of the cache's subdirectories, skipping temporary files.
+func (c *Cache) walk(dir string, fn func(path string, info os.FileInfo) error) error {
+	root := filepath.Join(c.Dir, dir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return err
+		}
+		return fn(path, info)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) path(dir, name string) string {
+	return filepath.Join(c.Dir, dir, name)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Keeps names that come from the index (hash algorithms, digests and filenames) from escaping the cache directory or being taken for
+// temporary files.
+func safeName(name string) string {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return hashKey(name)
+	}
+	return name
+}
+
+// Updates the modification time of an entry, to record that it was used.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Writes a file by renaming a temporary file into place, so that readers never see a partially written file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
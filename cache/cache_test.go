@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPage(t *testing.T) {
+	c := New(t.TempDir(), time.Hour)
+	if page, _ := c.Page("https://pypi.org/simple/foo/"); page != nil {
+		t.Errorf("want no page in an empty cache, got %+v", page)
+	}
+
+	if err := c.PutPage(&Page{Key: "https://pypi.org/simple/foo/", Body: []byte("foo"), ETag: `"v1"`, Fetched: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	page, fresh := c.Page("https://pypi.org/simple/foo/")
+	if page == nil || string(page.Body) != "foo" || page.ETag != `"v1"` || !fresh {
+		t.Errorf("want fresh page with body foo and ETag \"v1\", got %+v (fresh: %v)", page, fresh)
+	}
+
+	c.TTL = 0
+	if page, fresh := c.Page("https://pypi.org/simple/foo/"); page == nil || fresh {
+		t.Errorf("want stale page with zero TTL, got %+v (fresh: %v)", page, fresh)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	c := New(t.TempDir(), 0)
+	path, err := c.PrepareBlob("sha256", "abc123", "foo-1.0.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutMetadata("sha256:abc123 requires.txt", []byte("bar\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, in := c.Metadata("sha256:abc123 requires.txt"); !in || string(b) != "bar\n" {
+		t.Errorf("want metadata bar, got %q (in cache: %v)", b, in)
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != (Count{1, 7}) || stats.Metadata != (Count{1, 4}) || stats.Pages != (Count{}) {
+		t.Errorf("want 1 archive of 7 bytes and 1 metadata entry of 4 bytes, got %+v", stats)
+	}
+
+	// Only entries that haven't been used recently are pruned
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := c.Prune(24 * time.Hour); err != nil || removed != 1 {
+		t.Errorf("want 1 entry pruned, got %d (error: %v)", removed, err)
+	}
+	if _, in := c.Blob("sha256", "abc123", "foo-1.0.tar.gz"); in {
+		t.Errorf("want pruned archive removed")
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, in := c.Metadata("sha256:abc123 requires.txt"); in {
+		t.Errorf("want metadata removed by Clear")
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../../etc", `a\b`, ".hidden"} {
+		if got := safeName(name); got == name || len(got) != 64 {
+			t.Errorf("safeName(%q): want a hash, got %q", name, got)
+		}
+	}
+	if got := safeName("foo-1.0.tar.gz"); got != "foo-1.0.tar.gz" {
+		t.Errorf("safeName: want foo-1.0.tar.gz unchanged, got %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package cheerio
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/beyang/cheerio/cache"
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestCachedArchives(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{
+		{Version: "1.0", RequiresTxt: "bar>=2.0\n", TopLevelTxt: "foo\n"},
+	}})
+	defer server.Close()
+	c := cache.New(t.TempDir(), 0)
+	archivePath := "/packages/" + pypitest.Filename("foo", "1.0", pypitest.Sdist)
+
+	for run := 0; run < 2; run++ {
+		// A new index each run, as in separate processes sharing the cache
+		index := &PackageIndex{URI: server.URL, Cache: c}
+		reqs, err := index.FetchPackageRequirements("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 1 || reqs[0].Name != "bar" {
+			t.Errorf("run %d: want requirement bar, got %+v", run, reqs)
+		}
+		modules, err := index.FetchSourceTopLevelModules("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(modules) != 1 || modules[0] != "foo" {
+			t.Errorf("run %d: want top-level module foo, got %v", run, modules)
+		}
+	}
+	if got := server.Requests(archivePath); got != 1 {
+		t.Errorf("want the archive downloaded once, got %d downloads", got)
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs.Entries != 1 || stats.Metadata.Entries != 2 {
+		t.Errorf("want 1 archive and 2 metadata entries, got %+v", stats)
+	}
+}
+
+func TestCachedArchivesWithoutHashes(t *testing.T) {
+	archives := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0", RequiresTxt: "bar\n"}}})
+	defer archives.Close()
+	archivePath := "/packages/" + pypitest.Filename("foo", "1.0", pypitest.Sdist)
+	// An index that links to the archive without a hash
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s%s">%s</a>`, archives.URL, archivePath, path.Base(archivePath))
+	}))
+	defer server.Close()
+	c := cache.New(t.TempDir(), 0)
+
+	// The archive is downloaded each time, so a change to it is seen
+	for _, want := range []string{"bar", "baz"} {
+		archives.AddProject(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0", RequiresTxt: want + "\n"}}})
+		index := &PackageIndex{URI: server.URL, Cache: c}
+		for i := 0; i < 2; i++ {
+			reqs, err := index.FetchPackageRequirements("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reqs) != 1 || reqs[0].Name != want {
+				t.Errorf("want requirement %s, got %+v", want, reqs)
+			}
+		}
+	}
+	if got := archives.Requests(archivePath); got != 4 {
+		t.Errorf("want the archive downloaded 4 times, got %d downloads", got)
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs.Entries != 0 || stats.Metadata.Entries != 0 {
+		t.Errorf("want nothing cached for an archive without a hash, got %+v", stats)
+	}
+}
+
+func TestCachedPages(t *testing.T) {
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintln(w, `<a href="/packages/foo-1.0.tar.gz">foo-1.0.tar.gz</a>`)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	// Within the TTL, the cached page is used without a request
+	index := &PackageIndex{URI: server.URL, Cache: cache.New(dir, time.Hour)}
+	for i := 0; i < 2; i++ {
+		if releases, err := index.Releases("foo"); err != nil || len(releases) != 1 {
+			t.Fatalf("want 1 release, got %d (error: %v)", len(releases), err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("fresh page: want 1 request, got %d", requests)
+	}
+
+	// Once stale, the page is revalidated, and the cached body used if it hasn't changed
+	index = &PackageIndex{URI: server.URL, Cache: cache.New(dir, 0)}
+	if releases, err := index.Releases("foo"); err != nil || len(releases) != 1 {
+		t.Fatalf("want 1 release, got %d (error: %v)", len(releases), err)
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("stale page: want 2 requests with 1 revalidated, got %d requests with %d revalidated", requests, notModified)
+	}
+}
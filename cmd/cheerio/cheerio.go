@@ -2,30 +2,48 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/beyang/cheerio"
+	"github.com/beyang/cheerio/cache"
+	"github.com/beyang/cheerio/fetch"
 )
 
 const (
 	Cmd_Repo     = "repo"
+	Cmd_Info     = "info"
 	Cmd_Reqs     = "reqs"
 	Cmd_ReqsDir  = "reqsdir"
 	Cmd_ReqGen   = "reqs-generate"
 	Cmd_TopLevel = "toplevel"
+	Cmd_Releases = "releases"
+	Cmd_Mirror   = "mirror"
+	Cmd_Serve    = "serve"
+	Cmd_Cache    = "cache"
 )
 
 var Commands = map[string]func(args []string, flags *flag.FlagSet){
 	Cmd_Repo:     mainRepo,
+	Cmd_Info:     mainInfo,
 	Cmd_Reqs:     mainReqs,
 	Cmd_ReqsDir:  mainReqsDir,
 	Cmd_ReqGen:   mainReqGen,
 	Cmd_TopLevel: mainTopLevel,
+	Cmd_Releases: mainReleases,
+	Cmd_Mirror:   mainMirror,
+	Cmd_Serve:    mainServe,
+	Cmd_Cache:    mainCache,
 }
 
 func main() {
@@ -55,10 +73,131 @@ func main() {
 	os.Exit(1)
 }
 
+const (
+	envCacheDir     = "CHEERIO_CACHE_DIR"
+	defaultCacheTTL = 10 * time.Minute
+)
+
+// Number of HTTP requests retried so far
+var retryCount int64
+
+// Flag value that collects the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Adds flags that select the package indexes to query and configure HTTP requests, and returns a function that builds the selected index once
+// the flags are parsed.
+func indexFlags(flags *flag.FlagSet) func() cheerio.Index {
+	indexURL := flags.String("index-url", cheerio.DefaultPyPI.URI, "Base URL of the primary package index")
+	var extraIndexURLs stringList
+	flags.Var(&extraIndexURLs, "extra-index-url", "Base URL of an extra package index, queried after the primary index (may be repeated)")
+	merge := flags.Bool("merge-indexes", false, "Merge the files of all indexes, rather than using the first index that lists a package")
+	timeout := flags.Duration("timeout", fetch.DefaultTimeout, "Time limit for each HTTP request, including the download")
+	userAgent := flags.String("user-agent", fetch.DefaultUserAgent, "User-Agent header for HTTP requests")
+	proxy := flags.String("proxy", "", "Proxy URL for HTTP requests.  Defaults to $HTTPS_PROXY or $HTTP_PROXY")
+	retries := flags.Int("retries", fetch.DefaultRetryPolicy.MaxRetries, "Number of times to retry requests that fail transiently")
+	maxBackoff := flags.Duration("retry-max-backoff", fetch.DefaultRetryPolicy.MaxBackoff, "Longest wait before retrying a request")
+	unverifiedRanges := flags.Bool("unverified-range-reads", true, "Read only the metadata of zips, eggs and wheels with Range requests, "+
+		"without checking their hashes.  Set to false to download and check whole archives")
+	cacheDir := flags.String("cache-dir", os.Getenv(envCacheDir), "Directory to cache index pages and archives in.  Defaults to $"+envCacheDir+"; if empty, nothing is cached")
+	cacheTTL := flags.Duration("cache-ttl", defaultCacheTTL, "How long to use cached index pages before revalidating them")
+
+	return func() cheerio.Index {
+		client := &fetch.Client{
+			Timeout:   *timeout,
+			UserAgent: *userAgent,
+			RetryPolicy: &fetch.RetryPolicy{
+				MaxRetries: *retries,
+				MinBackoff: fetch.DefaultRetryPolicy.MinBackoff,
+				MaxBackoff: *maxBackoff,
+			},
+			OnRetry:              func(uri string, attempt int, err error) { atomic.AddInt64(&retryCount, 1) },
+			UnverifiedRangeReads: *unverifiedRanges,
+		}
+		if *proxy != "" {
+			proxyURL, err := url.Parse(*proxy)
+			if err != nil {
+				// Don't echo the URL, which may contain credentials
+				fmt.Fprintln(os.Stderr, "Invalid proxy URL")
+				os.Exit(1)
+			}
+			client.HTTPClient = fetch.NewHTTPClient(proxyURL)
+		}
+
+		var c *cache.Cache
+		if *cacheDir != "" {
+			c = cache.New(*cacheDir, *cacheTTL)
+		}
+
+		primary := &cheerio.PackageIndex{URI: *indexURL, EnvCredentials: true, Client: client, Cache: c}
+		if len(extraIndexURLs) == 0 {
+			return primary
+		}
+
+		var extra []*cheerio.PackageIndex
+		for _, uri := range extraIndexURLs {
+			extra = append(extra, &cheerio.PackageIndex{URI: uri, Client: client, Cache: c})
+		}
+		indexes := cheerio.NewIndexSet(primary, extra...)
+		if *merge {
+			indexes.Mode = cheerio.MergeMode
+		}
+		return indexes
+	}
+}
+
+// Adds a flag for recognizing repositories on more code hosts, and returns a function that builds the index as the given one does, recognizing
+// them too, once the flags are parsed.
+func repoHostFlags(flags *flag.FlagSet, index func() cheerio.Index) func() cheerio.Index {
+	var hosts stringList
+	flags.Var(&hosts, "repo-host", "Also recognize repositories on a code host, given as <kind>:<host-pattern> (e.g., gitlab:git.example.com or "+
+		"gitea:*.git.example.com), where kind is github, gitlab, gitea, bitbucket, sourcehut or launchpad (may be repeated)")
+
+	return func() cheerio.Index {
+		repoHosts := append([]*cheerio.RepoHost(nil), cheerio.DefaultRepoHosts...)
+		for _, host := range hosts {
+			parts := strings.SplitN(host, ":", 2)
+			if len(parts) != 2 || parts[1] == "" {
+				fmt.Fprintf(os.Stderr, "Invalid code host %q: want <kind>:<host-pattern>\n", host)
+				os.Exit(1)
+			}
+			kind, err := cheerio.ParseHostKind(parts[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			// Ahead of the defaults, so that they can be overridden
+			repoHosts = append([]*cheerio.RepoHost{{Pattern: parts[1], Kind: kind}}, repoHosts...)
+		}
+
+		idx := index()
+		switch idx := idx.(type) {
+		case *cheerio.PackageIndex:
+			idx.RepoHosts = repoHosts
+		case *cheerio.IndexSet:
+			idx.RepoHosts = repoHosts
+		}
+		return idx
+	}
+}
+
 func mainRepo(args []string, flags *flag.FlagSet) {
 	flags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s %s <package-name>\n", os.Args[0], args[0])
+		flags.PrintDefaults()
 	}
+	index := repoHostFlags(flags, indexFlags(flags))
+	asJSON := flags.Bool("json", false, "Print the repository in canonical form as JSON: its host, owner, name, VCS, clone and web URLs and the "+
+		"package's subdirectory")
+	candidates := flags.Bool("candidates", false, "Print every candidate repository, ranked by confidence, with the links it was inferred from")
 	flags.Parse(args[1:])
 
 	if flags.NArg() < 1 {
@@ -68,7 +207,50 @@ func mainRepo(args []string, flags *flag.FlagSet) {
 
 	pkg := cheerio.NormalizedPkgName(flags.Arg(0))
 
-	repo, err := cheerio.DefaultPyPI.FetchSourceRepoURL(pkg)
+	if *candidates {
+		repoCandidates, err := index().FetchSourceRepoCandidates(pkg)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(repoCandidates); err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding output: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		for _, candidate := range repoCandidates {
+			fmt.Printf("%.2f %s\n", candidate.Confidence, candidate.Repo)
+			for _, evidence := range candidate.Evidence {
+				if evidence.Label != "" {
+					fmt.Printf("     %s (%s): %s\n", evidence.Kind, evidence.Label, evidence.URL)
+				} else {
+					fmt.Printf("     %s: %s\n", evidence.Kind, evidence.URL)
+				}
+			}
+		}
+		return
+	}
+
+	if *asJSON {
+		repo, err := index().FetchSourceRepo(pkg)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(repo); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding output: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	repo, err := index().FetchSourceRepoURL(pkg)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 	} else {
@@ -76,10 +258,83 @@ func mainRepo(args []string, flags *flag.FlagSet) {
 	}
 }
 
+func mainInfo(args []string, flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s <package-name>\n", os.Args[0], args[0])
+		flags.PrintDefaults()
+	}
+	index := indexFlags(flags)
+	asJSON := flags.Bool("json", false, "Print all of the metadata as JSON")
+	description := flags.Bool("description", false, "Also print the description")
+	flags.Parse(args[1:])
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	pkg := cheerio.NormalizedPkgName(flags.Arg(0))
+
+	m, err := index().FetchMetadata(pkg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(m); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding output: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Print the fields in core metadata form, leaving out those without a value
+	printField := func(name, value string) {
+		if value != "" {
+			fmt.Printf("%s: %s\n", name, value)
+		}
+	}
+	printField("Name", m.Name)
+	printField("Version", m.Version)
+	printField("Summary", m.Summary)
+	printField("Home-page", m.HomePage)
+	printField("Download-URL", m.DownloadURL)
+	for _, u := range m.ProjectURLs {
+		if u.Label != "" {
+			printField("Project-URL", u.Label+", "+u.URL)
+		} else {
+			printField("Project-URL", u.URL)
+		}
+	}
+	printField("Author", m.Author)
+	printField("Author-email", m.AuthorEmail)
+	printField("Maintainer", m.Maintainer)
+	printField("Maintainer-email", m.MaintainerEmail)
+	printField("License-Expression", m.LicenseExpression)
+	printField("License", strings.Replace(m.License, "\n", "\n        ", -1))
+	printField("Requires-Python", m.RequiresPython)
+	for _, req := range m.RequiresDist {
+		printField("Requires-Dist", req)
+	}
+	for _, extra := range m.ProvidesExtra {
+		printField("Provides-Extra", extra)
+	}
+	for _, classifier := range m.Classifiers {
+		printField("Classifier", classifier)
+	}
+	if *description && m.Description != "" {
+		fmt.Printf("\n%s\n", m.Description)
+	}
+}
+
 func mainTopLevel(args []string, flags *flag.FlagSet) {
 	flags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s %s <package-name>\n", os.Args[0], args[0])
+		flags.PrintDefaults()
 	}
+	index := indexFlags(flags)
 	flags.Parse(args[1:])
 
 	if flags.NArg() < 1 {
@@ -89,7 +344,7 @@ func mainTopLevel(args []string, flags *flag.FlagSet) {
 
 	pkg := cheerio.NormalizedPkgName(flags.Arg(0))
 
-	modules, err := cheerio.DefaultPyPI.FetchSourceTopLevelModules(pkg)
+	modules, err := index().FetchSourceTopLevelModules(pkg)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 	} else {
@@ -97,6 +352,189 @@ func mainTopLevel(args []string, flags *flag.FlagSet) {
 	}
 }
 
+func mainReleases(args []string, flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s <package-name>\n", os.Args[0], args[0])
+		flags.PrintDefaults()
+	}
+	index := indexFlags(flags)
+	flags.Parse(args[1:])
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	pkg := cheerio.NormalizedPkgName(flags.Arg(0))
+
+	idx := index()
+	_, multipleIndexes := idx.(*cheerio.IndexSet)
+	releases, err := idx.Releases(pkg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	for _, release := range releases {
+		line := release.Version
+		if uploaded := release.UploadTime(); !uploaded.IsZero() {
+			line += " " + uploaded.UTC().Format("2006-01-02")
+		}
+		if requiresPython := release.RequiresPython(); requiresPython != "" {
+			line += " requires-python:" + requiresPython
+		}
+		if release.Yanked() {
+			line += " [yanked"
+			if reason := release.YankedReason(); reason != "" {
+				line += ": " + reason
+			}
+			line += "]"
+		}
+		fmt.Println(line)
+		for _, file := range release.Files {
+			if multipleIndexes {
+				fmt.Printf("  %s (%s)\n", file.Filename, file.Index)
+			} else {
+				fmt.Printf("  %s\n", file.Filename)
+			}
+		}
+	}
+}
+
+func mainMirror(args []string, flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s -dir=<mirror-dir> [-r <requirements-file>] [<package-name> ...]\n", os.Args[0], args[0])
+		flags.PrintDefaults()
+	}
+	index := indexFlags(flags)
+	dir := flags.String("dir", "", "Directory to write the mirror to")
+	reqFile := flags.String("r", "", "Mirror the packages in this requirements file")
+	deps := flags.Bool("deps", false, "Also mirror the transitive requirements of each package, according to the PyPI graph")
+	graphFile := flags.String("graphfile", "", "Path to PyPI dependency graph file for -deps.  Defaults to $GOPATH/src/github.com/beyang/cheerio/data/pypi_graph")
+	allVersions := flags.Bool("all-versions", false, "Mirror every release, rather than the latest release that satisfies each requirement")
+	flags.Parse(args[1:])
+
+	if *dir == "" || (flags.NArg() < 1 && *reqFile == "") {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var reqs []*cheerio.Requirement
+	if *reqFile != "" {
+		b, err := ioutil.ReadFile(*reqFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading requirements file: %s\n", err)
+			os.Exit(1)
+		}
+		fileReqs, err := cheerio.ParseRequirements(string(b))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing requirements file: %s\n", err)
+			os.Exit(1)
+		}
+		reqs = append(reqs, fileReqs...)
+	}
+	for _, arg := range flags.Args() {
+		req, err := cheerio.ParseRequirement(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing requirement: %s\n", err)
+			os.Exit(1)
+		}
+		reqs = append(reqs, req)
+	}
+
+	opts := &cheerio.MirrorOptions{
+		AllVersions: *allVersions,
+		OnDownload: func(file *cheerio.DistFile) {
+			log.Printf("[download] %s\n", file.Filename)
+		},
+	}
+	if *deps {
+		opts.Graph = cheerio.DefaultPyPIGraph
+		if *graphFile != "" {
+			var err error
+			if opts.Graph, err = cheerio.NewPyPIGraph(*graphFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating PyPI graph: %s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if err := cheerio.Mirror(index(), reqs, *dir, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error mirroring packages: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func mainServe(args []string, flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [-addr=<host:port>] [<dir>]\n", os.Args[0], args[0])
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "Address to listen on")
+	refresh := flags.Duration("refresh", cheerio.DefaultRefreshInterval, "How often to list the directory again for added or removed files")
+	flags.Parse(args[1:])
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	log.Printf("Serving %s as a simple index at http://%s/simple/\n", dir, *addr)
+	server := cheerio.NewIndexServer(dir)
+	server.RefreshInterval = *refresh
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving index: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func mainCache(args []string, flags *flag.FlagSet) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [-cache-dir=<dir>] stats|prune|clear\n", os.Args[0], args[0])
+		flags.PrintDefaults()
+	}
+	// The same default as the other commands, so that this acts on the cache they use
+	dir := flags.String("cache-dir", os.Getenv(envCacheDir), "Cache directory.  Defaults to $"+envCacheDir)
+	maxAge := flags.Duration("max-age", 30*24*time.Hour, "With prune, remove entries that haven't been used for this long")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *dir == "" {
+		fmt.Fprintf(os.Stderr, "No cache directory: give -cache-dir or set $%s (e.g., to %s)\n", envCacheDir, cache.DefaultDir())
+		os.Exit(1)
+	}
+
+	c := cache.New(*dir, 0)
+	switch flags.Arg(0) {
+	case "stats":
+		stats, err := c.Stats()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", *dir)
+		fmt.Printf("  index pages: %6d entries %12d bytes\n", stats.Pages.Entries, stats.Pages.Bytes)
+		fmt.Printf("  archives:    %6d entries %12d bytes\n", stats.Blobs.Entries, stats.Blobs.Bytes)
+		fmt.Printf("  metadata:    %6d entries %12d bytes\n", stats.Metadata.Entries, stats.Metadata.Bytes)
+	case "prune":
+		removed, err := c.Prune(*maxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning cache: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d entries\n", removed)
+	case "clear":
+		if err := c.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error clearing cache: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		flags.Usage()
+		os.Exit(1)
+	}
+}
+
 func mainReqsDir(args []string, flags *flag.FlagSet) {
 	flags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "")
@@ -163,8 +601,29 @@ func mainReqs(args []string, flags *flag.FlagSet) {
 // pkg1:pkg3
 // pkg2
 // pkg2:pkg4
+//
+// With -repos or -toplevel, also writes the source repository URL or the top-level modules of each package to the given file, one package per
+// line ("pkg1 <url>" or "pkg1 module1 module2"). All of this data is read from a single download of each package's archive.
 func mainReqGen(args []string, flags *flag.FlagSet) {
-	pkgIndex := &cheerio.DefaultPyPI
+	index := repoHostFlags(flags, indexFlags(flags))
+	reposFile := flags.String("repos", "", "Also write the source repository URL of each package to this file")
+	topLevelFile := flags.String("toplevel", "", "Also write the top-level modules of each package to this file")
+	flags.Parse(args[1:])
+
+	createOutput := func(path string) *os.File {
+		if path == "" {
+			return nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("[FATAL] %s\n", err))
+			os.Exit(1)
+		}
+		return f
+	}
+	reposOut, topLevelOut := createOutput(*reposFile), createOutput(*topLevelFile)
+
+	pkgIndex := index()
 	pkgs, err := pkgIndex.AllPackages()
 	if err != nil {
 		os.Stderr.WriteString(fmt.Sprintf("[FATAL] %s\n", err))
@@ -185,9 +644,28 @@ func mainReqGen(args []string, flags *flag.FlagSet) {
 			defer waiter.Done()
 			defer func() { <-throttle }()
 
-			reqs, err := pkgIndex.FetchPackageRequirements(pkg)
+			var reqs []*cheerio.Requirement
+			var err error
+			if reposOut == nil && topLevelOut == nil {
+				reqs, err = pkgIndex.FetchPackageRequirements(pkg)
+			} else {
+				var bundle *cheerio.MetadataBundle
+				if bundle, err = pkgIndex.FetchMetadataBundle(pkg); err == nil {
+					reqs, err = bundle.Requirements()
+
+					stdoutMu.Lock()
+					if repoURL, err := bundle.SourceRepoURL(); reposOut != nil && err == nil {
+						fmt.Fprintf(reposOut, "%s %s\n", cheerio.NormalizedPkgName(pkg), repoURL)
+					}
+					if modules, err := bundle.TopLevelModules(); topLevelOut != nil && err == nil && len(modules) > 0 {
+						fmt.Fprintf(topLevelOut, "%s %s\n", cheerio.NormalizedPkgName(pkg), strings.Join(modules, " "))
+					}
+					stdoutMu.Unlock()
+				}
+			}
+			var noMatch *fetch.NoMatchError
 			if err != nil {
-				if !strings.Contains(err.Error(), "No file matched pattern") { // ignore archives that don't contain requires.txt
+				if !errors.As(err, &noMatch) { // ignore archives that don't contain requires.txt
 					os.Stderr.WriteString(fmt.Sprintf("[ERROR] unable to parse pkg %s due to error: %s\n", pkg, err))
 				}
 			} else {
@@ -201,11 +679,20 @@ func mainReqGen(args []string, flags *flag.FlagSet) {
 
 			pkgsCompleteMu.Lock()
 			if pkgsComplete%50 == 0 {
-				log.Printf("[status] %d / %d\n", pkgsComplete, len(pkgs))
+				log.Printf("[status] %d / %d (%d retries)\n", pkgsComplete, len(pkgs), atomic.LoadInt64(&retryCount))
 			}
 			pkgsComplete++
 			pkgsCompleteMu.Unlock()
 		}()
 	}
 	waiter.Wait()
+
+	for _, f := range []*os.File{reposOut, topLevelOut} {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				os.Stderr.WriteString(fmt.Sprintf("[FATAL] %s\n", err))
+				os.Exit(1)
+			}
+		}
+	}
 }
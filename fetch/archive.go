@@ -0,0 +1,197 @@
+package fetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+)
+
+// Archives are read by walking their members in order and passing each to a visitor, which decides what to do with it: collect it if it
+// matches a pattern, or write it to disk (see extract.go). The walkers enforce the limits on an archive as a whole; visitors that read a
+// member enforce the limit on its size.
+
+// A member of an archive.
+type member struct {
+	name     string
+	mode     os.FileMode // the type and permissions
+	linkname string      // the target of a symlink or hard link
+	hardLink bool
+	size     int64
+	open     func() (io.ReadCloser, error)
+}
+
+// Walks an archive stream, detecting its format from its magic bytes.
+func walkStream(uri string, body io.Reader, lim Limits, visit func(*member) error) error {
+	archiveType, compression, body, err := DetectCompression(body)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %w", uri, err)
+	}
+	if archiveType == Zip {
+		return walkZipStream(uri, body, lim, visit)
+	}
+	return walkTar(uri, body, compression, lim, visit)
+}
+
+// Walks an archive that can be read at random, detecting its format from its magic bytes. Zips are read in place rather than copied.
+func walkReaderAt(uri string, r io.ReaderAt, size int64, lim Limits, visit func(*member) error) error {
+	if err := checkLimit(uri, ArchiveSizeLimit, size, lim.MaxArchiveSize); err != nil {
+		return err
+	}
+	archiveType, compression, body, err := DetectCompression(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %w", uri, err)
+	}
+	if archiveType == Zip {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return fmt.Errorf("Error unzipping %s: %w", uri, err)
+		}
+		return walkZip(uri, zr, lim, visit)
+	}
+	return walkTar(uri, body, compression, lim, visit)
+}
+
+func walkTar(uri string, body io.Reader, compression Compression, lim Limits, visit func(*member) error) error {
+	decompressed, err := decompress(body, compression)
+	if err != nil {
+		return fmt.Errorf("Error decompressing %s: %w", uri, err)
+	}
+	defer decompressed.Close()
+
+	// Members that aren't read are still decompressed to skip over them, so they count toward the total size
+	contents := limitReader(decompressed, uri, TotalSizeLimit, lim.MaxTotalSize)
+	tr := tar.NewReader(contents)
+	open := func() (io.ReadCloser, error) { return ioutil.NopCloser(tr), nil }
+	for count := 1; ; count++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if limitErr := exceeded(contents); limitErr != nil {
+			return limitErr
+		} else if err != nil {
+			return fmt.Errorf("Error untarring %s (may be malformed): %w", uri, err)
+		}
+		if err := checkLimit(uri, FileCountLimit, int64(count), int64(lim.MaxFiles)); err != nil {
+			return err
+		}
+
+		m := &member{
+			name:     hdr.Name,
+			mode:     hdr.FileInfo().Mode(),
+			linkname: hdr.Linkname,
+			hardLink: hdr.Typeflag == tar.TypeLink,
+			size:     hdr.Size,
+			open:     open,
+		}
+		if err := visit(m); err != nil {
+			if limitErr := exceeded(contents); limitErr != nil {
+				return limitErr
+			}
+			return err
+		}
+	}
+}
+
+// Walks a zip stream, spooling it to disk rather than memory, since it may be large.
+func walkZipStream(uri string, body io.Reader, lim Limits, visit func(*member) error) error {
+	tmp, err := ioutil.TempFile("", "cheerio-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return fmt.Errorf("Error unzipping %s: %w", uri, err)
+	}
+	return walkZip(uri, zr, lim, visit)
+}
+
+func walkZip(uri string, zr *zip.Reader, lim Limits, visit func(*member) error) error {
+	if err := checkLimit(uri, FileCountLimit, int64(len(zr.File)), int64(lim.MaxFiles)); err != nil {
+		return err
+	}
+	// A zip's directory gives the size of each member, so the total can be checked before anything is decompressed
+	var total int64
+	for _, file := range zr.File {
+		if file == nil {
+			return fmt.Errorf("Error unzipping %s: nil file (may be malformed)", uri)
+		}
+		total = addSize(total, file.UncompressedSize64)
+	}
+	if err := checkLimit(uri, TotalSizeLimit, total, lim.MaxTotalSize); err != nil {
+		return err
+	}
+
+	for _, file := range zr.File {
+		m := &member{name: file.Name, mode: file.Mode(), size: addSize(0, file.UncompressedSize64), open: file.Open}
+		if m.mode&os.ModeSymlink != 0 {
+			// A zip stores the target of a symlink as its contents
+			target, err := readMember(uri, m, lim)
+			if err != nil {
+				return err
+			}
+			m.linkname = string(target)
+		}
+		if err := visit(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the contents of a member, which must not exceed the file size limit.
+func readMember(uri string, m *member, lim Limits) ([]byte, error) {
+	if err := checkLimit(uri, FileSizeLimit, m.size, lim.MaxFileSize); err != nil {
+		return nil, err
+	}
+	r, err := m.open()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s from %s: %w", m.name, uri, err)
+	}
+	defer r.Close()
+	// The header could understate the member's size, so its contents are limited too
+	data, err := ioutil.ReadAll(limitReader(r, uri, FileSizeLimit, lim.MaxFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s from %s: %w", m.name, uri, err)
+	}
+	return data, nil
+}
+
+// Collects the members that match a pattern, in archive order.
+type collector struct {
+	uri     string
+	pattern *regexp.Regexp
+	lim     Limits
+	files   []*archiveFile
+}
+
+func (c *collector) visit(m *member) error {
+	if !c.pattern.MatchString(m.name) {
+		return nil
+	}
+	data, err := readMember(c.uri, m, c.lim)
+	if err != nil {
+		return err
+	}
+	c.files = append(c.files, &archiveFile{name: m.name, data: data})
+	return nil
+}
+
+// Adds a member size from a zip directory to a total, saturating rather than overflowing.
+func addSize(total int64, size uint64) int64 {
+	if size > uint64(math.MaxInt64-total) {
+		return math.MaxInt64
+	}
+	return total + int64(size)
+}
@@ -0,0 +1,170 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Makes the HTTP requests for downloading archives. The zero value is ready to use.
+type Client struct {
+	// The underlying HTTP client. If nil, DefaultHTTPClient is used.
+	HTTPClient *http.Client
+
+	// Sent as the User-Agent header. If empty, DefaultUserAgent is used.
+	UserAgent string
+
+	// Limits the time for each request, including reading the response body. If zero, DefaultTimeout is used; if negative, there is no limit.
+	Timeout time.Duration
+
+	// If non-nil, called on each request before it is sent, e.g., to add credentials.
+	Authorize func(req *http.Request)
+
+	// How failed requests are retried. If nil, DefaultRetryPolicy is used.
+	RetryPolicy *RetryPolicy
+
+	// If non-nil, called before each retry with the number of the attempt that failed and its error.
+	OnRetry func(uri string, attempt int, err error)
+
+	// Zip archives without hashes to check are read with Range requests, when the server supports them, so only their directory and the
+	// members that are needed are downloaded. If UnverifiedRangeReads is set, archives with hashes are read that way too, and the hashes are
+	// not checked: the hash covers the whole archive, which isn't downloaded. Since PyPI publishes hashes for every file, this is what makes
+	// Range requests apply to it, at the cost of trusting the server for the members read. Whole downloads (DownloadFile) are always checked.
+	UnverifiedRangeReads bool
+
+	// Limits on the size of the archives read. If nil, DefaultLimits is used.
+	Limits *Limits
+
+	// Whether file:// URLs are served from the local filesystem. Set it only for an index on local disk: otherwise a remote index could link
+	// to local files (e.g., file:///home/u/.ssh/id_rsa), and have them read into metadata or copied into a mirror.
+	AllowFileURLs bool
+}
+
+// The Client used by the package-level functions.
+var DefaultClient = &Client{}
+
+const (
+	DefaultUserAgent = "cheerio (+https://github.com/beyang/cheerio)"
+	DefaultTimeout   = 5 * time.Minute
+)
+
+// The HTTP client used when a Client doesn't specify one. Unlike http.DefaultClient, it doesn't wait forever to connect or for response headers.
+// It uses the proxy given by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+var DefaultHTTPClient = NewHTTPClient(nil)
+
+// Returns an HTTP client with connection timeouts that sends requests through the given proxy. If proxy is nil, the proxy is taken from the
+// environment.
+func NewHTTPClient(proxy *url.URL) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Serves file:// URLs from the local filesystem.
+var fileTransport = http.NewFileTransport(http.Dir("/"))
+
+// Issues a GET request for the given URI, which is cancelled when ctx is done. Besides HTTP(S) URLs, file:// URLs are supported if the Client
+// allows them.
+func (c *Client) Get(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Sends a request, after setting its User-Agent and authorizing it. The timeout covers reading the response body, so the body must be closed.
+// Requests for file:// URLs are served from the local filesystem if AllowFileURLs is set, and fail otherwise. Either way, reading the body fails
+// once the request's context is done.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		if !c.AllowFileURLs {
+			return nil, fmt.Errorf("Not allowed to read local file %s", req.URL)
+		}
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		resp, err := fileTransport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &contextBody{ReadCloser: resp.Body, ctx: req.Context()}
+		return resp, nil
+	}
+
+	req.Header.Set("User-Agent", c.userAgent())
+	if c.Authorize != nil {
+		c.Authorize(req)
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout < 0 {
+		return c.httpClient().Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return DefaultHTTPClient
+}
+
+func (c *Client) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+// A response body that releases the request's timeout when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// A response body whose reads fail once a context is done. The bodies of HTTP responses already behave this way; file:// responses don't.
+type contextBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *contextBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
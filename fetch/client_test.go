@@ -0,0 +1,71 @@
+package fetch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	var gotUserAgent string
+	hang := make(chan struct{})
+	defer close(hang)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.UserAgent()
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{Timeout: 50 * time.Millisecond, UserAgent: "test-agent"}
+	resp, err := c.Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if gotUserAgent != "test-agent" {
+		t.Errorf("want User-Agent test-agent, got %q", gotUserAgent)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("want timeout error reading a stalled body")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout did not apply to reading the response body")
+	}
+}
+
+func TestClientFileURLs(t *testing.T) {
+	path, err := filepath.Abs("client_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	if _, err := (&Client{}).Get(context.Background(), uri); err == nil {
+		t.Errorf("want error reading a file:// URL without AllowFileURLs")
+	}
+	resp, err := (&Client{AllowFileURLs: true}).Get(context.Background(), uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("want 200 OK, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,88 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The stream compressions a tarball may use.
+type Compression string
+
+const (
+	Uncompressed Compression = "none"
+	Gzip         Compression = "gzip"
+	Bzip2        Compression = "bzip2"
+	XZ           Compression = "xz"
+	Zstd         Compression = "zstd"
+)
+
+// The number of bytes DetectCompression examines: enough to reach the "ustar" magic of an uncompressed tar header.
+const sniffLen = 262
+
+var magics = []struct {
+	magic       []byte
+	archiveType CompressionType
+	compression Compression
+}{
+	{[]byte("PK\x03\x04"), Zip, ""},
+	{[]byte("PK\x05\x06"), Zip, ""}, // empty zip
+	{[]byte("\x1f\x8b"), Tar, Gzip},
+	{[]byte("BZh"), Tar, Bzip2},
+	{[]byte("\xfd7zXZ\x00"), Tar, XZ},
+	{[]byte("\x28\xb5\x2f\xfd"), Tar, Zstd},
+}
+
+// Identifies an archive from its first bytes, regardless of its filename. Zip archives (including eggs and wheels) are reported as Zip, with no
+// compression. Tarballs compressed with gzip, bzip2, xz or zstd, and uncompressed tarballs, are reported as Tar with their compression. Returns
+// an error if the format isn't recognized. The returned reader yields the whole stream, including the bytes that were examined.
+func DetectCompression(r io.Reader) (CompressionType, Compression, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", nil, err
+	}
+	head = head[:n]
+	whole := io.MultiReader(bytes.NewReader(head), r)
+
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.archiveType, m.compression, whole, nil
+		}
+	}
+	if len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")) {
+		return Tar, Uncompressed, whole, nil
+	}
+	return "", "", nil, fmt.Errorf("Unrecognized archive format")
+}
+
+// Returns a reader for the decompressed contents of a stream. The caller must close it.
+func decompress(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case Uncompressed:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Bzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case XZ:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("Unrecognized compression: %s", compression)
+}
@@ -0,0 +1,92 @@
+package fetch
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestDetectCompression(t *testing.T) {
+	files := map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"}
+	plain := tarball(t, files)
+
+	var xzBuf bytes.Buffer
+	xw, err := xz.NewWriter(&xzBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write(plain)
+	xw.Close()
+
+	var zstdBuf bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(plain)
+	zw.Close()
+
+	var zipBuf bytes.Buffer
+	zipw := zip.NewWriter(&zipBuf)
+	w, _ := zipw.Create("foo-1.0/PKG-INFO")
+	w.Write([]byte("Name: foo\n"))
+	zipw.Close()
+
+	tests := []struct {
+		name            string
+		archive         []byte
+		wantType        CompressionType
+		wantCompression Compression
+	}{
+		{"tar", plain, Tar, Uncompressed},
+		{"tar.gz", tarGz(t, files), Tar, Gzip},
+		{"tar.xz", xzBuf.Bytes(), Tar, XZ},
+		{"tar.zst", zstdBuf.Bytes(), Tar, Zstd},
+		{"zip", zipBuf.Bytes(), Zip, ""},
+	}
+	for _, test := range tests {
+		archiveType, compression, r, err := DetectCompression(bytes.NewReader(test.archive))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if archiveType != test.wantType || compression != test.wantCompression {
+			t.Errorf("%s: want %s/%s, got %s/%s", test.name, test.wantType, test.wantCompression, archiveType, compression)
+		}
+		if whole, _ := ioutil.ReadAll(r); !bytes.Equal(whole, test.archive) {
+			t.Errorf("%s: want the returned reader to yield the whole archive", test.name)
+		}
+	}
+
+	// Every format is read by content, even if the filename and type hint say otherwise. The server supports Range requests, so archives read
+	// as Zip are first tried with them.
+	var archive []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "foo-1.0.tar.gz", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer server.Close()
+	pattern := regexp.MustCompile(`PKG-INFO`)
+	for _, test := range tests {
+		for _, hint := range []CompressionType{Tar, Zip} {
+			archive = test.archive
+			data, err := RemoteDecompress(server.URL+"/foo-1.0.tar.gz", pattern, hint)
+			if err != nil {
+				t.Errorf("%s (read as %s): %s", test.name, hint, err)
+			} else if string(data) != "Name: foo\n" {
+				t.Errorf("%s (read as %s): unexpected data %q", test.name, hint, data)
+			}
+		}
+	}
+
+	if _, _, _, err := DetectCompression(bytes.NewReader([]byte("<html>Not Found</html>"))); err == nil {
+		t.Errorf("want error for unrecognized format")
+	}
+}
@@ -0,0 +1,64 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Checks a local file against the given hashes, as DownloadFile checks a download. Returns a *HashMismatchError if it doesn't match; like a
+// download, a file whose hashes can't be checked (see Hashes.Verifiable) always passes.
+func VerifyFile(path string, hashes Hashes) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	v := newVerifier(path, hashes)
+	if _, err := io.Copy(ioutil.Discard, v.reader(f)); err != nil {
+		return err
+	}
+	return v.verify()
+}
+
+// Downloads a file to the given path, checking it against the given hashes, and gives up when ctx is done. The download is written to a
+// temporary file that is renamed into place once it is complete and verified, so path never holds a partial or corrupt file.
+func (c *Client) DownloadFile(ctx context.Context, uri, path string, hashes Hashes) error {
+	return c.Retry(ctx, uri, func() error {
+		v := newVerifier(uri, hashes)
+		resp, err := c.Get(ctx, uri)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := CheckStatus(resp); err != nil {
+			return err
+		}
+		maxSize := c.limits().MaxArchiveSize
+		if err := checkLimit(uri, ArchiveSizeLimit, resp.ContentLength, maxSize); err != nil {
+			return err
+		}
+
+		tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, v.reader(limitReader(resp.Body, uri, ArchiveSizeLimit, maxSize)))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := v.verify(); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	})
+}
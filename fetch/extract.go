@@ -0,0 +1,230 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archives may come from untrusted uploads, so extracting one to disk never writes outside the target directory: members with absolute paths or
+// ".." elements are rejected, as are hard links that point outside it and symlinks whose targets are absolute or use "..", and nothing is
+// written through a symlink. Other special files (devices, FIFOs) are skipped.
+
+// Returned when an archive member would be written outside the directory it is extracted to.
+type UnsafePathError struct {
+	URI    string
+	Name   string // the member's path in the archive
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("Refusing to extract %s from %s: %s", e.Name, e.URI, e.Reason)
+}
+
+// Extracts all of an archive read from r into dir, which is created if it doesn't exist, and gives up when ctx is done. The archive's format is
+// detected from its contents. Returns an *UnsafePathError if a member would be written outside dir, or is a symlink whose target uses ".." or
+// is absolute.
+func (c *Client) ExtractTo(ctx context.Context, r io.Reader, dir string) error {
+	x, err := newExtractor(readerURI, dir, c.limits())
+	if err != nil {
+		return err
+	}
+	limited := limitReader(&contextReader{Reader: r, ctx: ctx}, readerURI, ArchiveSizeLimit, x.lim.MaxArchiveSize)
+	err = walkStream(readerURI, limited, x.lim, x.visit)
+	if limitErr := exceeded(limited); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// Like ExtractTo, but reads the archive at a local path.
+func (c *Client) ExtractFileTo(ctx context.Context, path, dir string) error {
+	f, size, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	x, err := newExtractor(path, dir, c.limits())
+	if err != nil {
+		return err
+	}
+	return walkReaderAt(path, &contextReaderAt{ReaderAt: f, ctx: ctx}, size, x.lim, x.visit)
+}
+
+// Like ExtractTo, but downloads the archive from uri first, checking it against the given hashes, so nothing is extracted from an archive that
+// doesn't match them.
+func (c *Client) RemoteExtractTo(ctx context.Context, uri, dir string, hashes Hashes) error {
+	tmp, err := ioutil.TempDir("", "cheerio-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	archive := filepath.Join(tmp, "archive")
+	if err := c.DownloadFile(ctx, uri, archive, hashes); err != nil {
+		return err
+	}
+	f, size, err := openArchive(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	x, err := newExtractor(uri, dir, c.limits())
+	if err != nil {
+		return err
+	}
+	return walkReaderAt(uri, &contextReaderAt{ReaderAt: f, ctx: ctx}, size, x.lim, x.visit)
+}
+
+// Writes the members of an archive under a directory.
+type extractor struct {
+	uri string
+	dir string // absolute
+	lim Limits
+}
+
+func newExtractor(uri, dir string, lim Limits) (*extractor, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &extractor{uri: uri, dir: dir, lim: lim}, nil
+}
+
+func (x *extractor) visit(m *member) error {
+	name, err := x.cleanName(m.name)
+	if err != nil {
+		return err
+	} else if name == "" {
+		return nil // the archive's root
+	}
+	dest := filepath.Join(x.dir, filepath.FromSlash(name))
+	if err := x.checkParents(m.name, name); err != nil {
+		return err
+	}
+
+	switch {
+	case m.mode.IsDir():
+		return os.MkdirAll(dest, 0755)
+	case m.hardLink:
+		target, err := x.cleanName(m.linkname)
+		if err != nil {
+			return err
+		} else if target == "" {
+			return &UnsafePathError{URI: x.uri, Name: m.name, Reason: "it is a hard link to a directory"}
+		}
+		if err := x.checkParents(m.name, target); err != nil {
+			return err
+		}
+		if err := x.prepare(dest); err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(x.dir, filepath.FromSlash(target)), dest)
+	case m.mode&os.ModeSymlink != 0:
+		if path.IsAbs(m.linkname) || filepath.IsAbs(m.linkname) {
+			return &UnsafePathError{URI: x.uri, Name: m.name, Reason: "it is a symlink to an absolute path"}
+		}
+		// Whether a target with ".." stays inside depends on the links it goes through, which later members can change (p -> . then
+		// t -> p/.. points above the link's directory), so only targets that descend from the link's directory are allowed. Every link
+		// then resolves somewhere below a directory that is itself inside, however links are chained or ordered.
+		if _, err := x.cleanName(m.linkname); err != nil {
+			return &UnsafePathError{URI: x.uri, Name: m.name, Reason: "it is a symlink to a path outside its directory"}
+		}
+		if err := x.prepare(dest); err != nil {
+			return err
+		}
+		return os.Symlink(m.linkname, dest)
+	case m.mode.IsRegular():
+		if err := x.prepare(dest); err != nil {
+			return err
+		}
+		return x.writeFile(m, dest)
+	}
+	return nil
+}
+
+// Returns the slash-separated path of an archive member relative to the target directory, or "" for the directory itself. Returns an
+// *UnsafePathError if the path is absolute or leaves the directory.
+func (x *extractor) cleanName(name string) (string, error) {
+	// Zips made on Windows may use backslashes
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(slashed) || filepath.VolumeName(name) != "" || (len(slashed) >= 2 && slashed[1] == ':') {
+		return "", &UnsafePathError{URI: x.uri, Name: name, Reason: "it has an absolute path"}
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", &UnsafePathError{URI: x.uri, Name: name, Reason: `its path contains ".."`}
+		}
+	}
+	clean := path.Clean(slashed)
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// Returns an *UnsafePathError if any directory between the target directory and a member is a symlink, since writing through it could leave
+// the target directory.
+func (x *extractor) checkParents(member, name string) error {
+	current := x.dir
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		current = filepath.Join(current, elem)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &UnsafePathError{URI: x.uri, Name: member, Reason: "its path goes through a symlink"}
+		}
+	}
+	return nil
+}
+
+// Creates the parent directories of a file to be written, and removes anything already at its path, so that an existing symlink isn't
+// followed.
+func (x *extractor) prepare(dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dest); err == nil && !info.IsDir() {
+		return os.Remove(dest)
+	}
+	return nil
+}
+
+func (x *extractor) writeFile(m *member, dest string) error {
+	if err := checkLimit(x.uri, FileSizeLimit, m.size, x.lim.MaxFileSize); err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if m.mode&0111 != 0 {
+		perm = 0755
+	}
+	r, err := m.open()
+	if err != nil {
+		return fmt.Errorf("Error reading %s from %s: %w", m.name, x.uri, err)
+	}
+	defer r.Close()
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, limitReader(r, x.uri, FileSizeLimit, x.lim.MaxFileSize))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error extracting %s from %s: %w", m.name, x.uri, err)
+	}
+	return nil
+}
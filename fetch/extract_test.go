@@ -0,0 +1,200 @@
+package fetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// An archive member for tarWith: a regular file unless typeflag says otherwise.
+type entry struct {
+	name     string
+	contents string
+	typeflag byte
+	linkname string
+	mode     int64
+}
+
+func tarWith(t *testing.T, entries ...entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: e.mode, Size: int64(len(e.contents))}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.contents[:hdr.Size]))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractTo(t *testing.T) {
+	archive := tarWith(t,
+		entry{name: "./", typeflag: tar.TypeDir},
+		entry{name: "foo-1.0/", typeflag: tar.TypeDir},
+		entry{name: "foo-1.0/PKG-INFO", contents: "Name: foo\n"},
+		entry{name: "foo-1.0/bin/run", contents: "#!/bin/sh\n", mode: 0755},
+		entry{name: "foo-1.0/README", typeflag: tar.TypeSymlink, linkname: "PKG-INFO"},
+		entry{name: "foo-1.0/here", typeflag: tar.TypeSymlink, linkname: "."},
+		entry{name: "foo-1.0/README.txt", typeflag: tar.TypeSymlink, linkname: "here/README"},
+		entry{name: "foo-1.0/bin/PKG-INFO", typeflag: tar.TypeLink, linkname: "foo-1.0/PKG-INFO"},
+		entry{name: "foo-1.0/fifo", typeflag: tar.TypeFifo},
+	)
+	dir, err := ioutil.TempDir("", "cheerio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Extracting twice overwrites the first extraction
+	for i := 0; i < 2; i++ {
+		if err := DefaultClient.ExtractTo(context.Background(), bytes.NewReader(archive), dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{"PKG-INFO": "Name: foo\n", "README": "Name: foo\n", "README.txt": "Name: foo\n", "bin/PKG-INFO": "Name: foo\n", "bin/run": "#!/bin/sh\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "foo-1.0", name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(data) != want {
+			t.Errorf("%s: want %q, got %q", name, want, data)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "foo-1.0/bin/run")); err != nil || info.Mode()&0100 == 0 {
+		t.Errorf("want bin/run to be executable")
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "foo-1.0/README")); err != nil || target != "PKG-INFO" {
+		t.Errorf("want README to be a symlink to PKG-INFO, got %q (%v)", target, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "foo-1.0/fifo")); !os.IsNotExist(err) {
+		t.Errorf("want special files to be skipped")
+	}
+}
+
+func TestExtractToUnsafePaths(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create(`foo\..\..\evil`)
+	w.Write([]byte("evil"))
+	zw.Close()
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"parent", tarWith(t, entry{name: "../evil", contents: "evil"})},
+		{"nested parent", tarWith(t, entry{name: "foo/../../evil", contents: "evil"})},
+		{"absolute", tarWith(t, entry{name: "/tmp/evil", contents: "evil"})},
+		{"backslashes", zipBuf.Bytes()},
+		{"symlink out", tarWith(t, entry{name: "foo/link", typeflag: tar.TypeSymlink, linkname: "../../evil"})},
+		{"absolute symlink", tarWith(t, entry{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"})},
+		{"hard link out", tarWith(t, entry{name: "link", typeflag: tar.TypeLink, linkname: "../evil"})},
+		{"write through symlink", tarWith(t,
+			entry{name: "foo/", typeflag: tar.TypeDir},
+			entry{name: "foo/sub", typeflag: tar.TypeSymlink, linkname: "bar"},
+			entry{name: "foo/sub/evil", contents: "evil"},
+		)},
+		{"symlink out through symlink", tarWith(t,
+			entry{name: "p", typeflag: tar.TypeSymlink, linkname: "."},
+			entry{name: "t", typeflag: tar.TypeSymlink, linkname: "p/.."},
+		)},
+		{"symlink out before its symlink", tarWith(t,
+			entry{name: "t", typeflag: tar.TypeSymlink, linkname: "p/../x"},
+			entry{name: "p", typeflag: tar.TypeSymlink, linkname: "."},
+		)},
+	}
+	for _, test := range tests {
+		root, err := ioutil.TempDir("", "cheerio-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		dir := filepath.Join(root, "a", "b")
+
+		err = DefaultClient.ExtractTo(context.Background(), bytes.NewReader(test.archive), dir)
+		var unsafe *UnsafePathError
+		if !errors.As(err, &unsafe) {
+			t.Errorf("%s: want *UnsafePathError, got %v", test.name, err)
+		}
+		for _, path := range []string{filepath.Join(root, "evil"), filepath.Join(root, "a", "evil")} {
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("%s: %s was written", test.name, path)
+			}
+		}
+	}
+}
+
+func TestRemoteExtractTo(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+	root, err := ioutil.TempDir("", "cheerio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	sum := sha256.Sum256(archive)
+	good := Hashes{"sha256": hex.EncodeToString(sum[:])}
+	bad := Hashes{"sha256": hex.EncodeToString(make([]byte, sha256.Size))}
+	uri := server.URL + "/foo-1.0.tar.gz"
+
+	if err := DefaultClient.RemoteExtractTo(context.Background(), uri, filepath.Join(root, "bad"), bad); err == nil {
+		t.Errorf("want *HashMismatchError, got nil")
+	} else if _, ok := err.(*HashMismatchError); !ok {
+		t.Errorf("want *HashMismatchError, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bad", "foo-1.0")); !os.IsNotExist(err) {
+		t.Errorf("want nothing extracted from an archive that doesn't match its hashes")
+	}
+
+	if err := DefaultClient.RemoteExtractTo(context.Background(), uri, filepath.Join(root, "good"), good); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "good", "foo-1.0", "PKG-INFO")); err != nil || string(data) != "Name: foo\n" {
+		t.Errorf("want %q, got %q (%v)", "Name: foo\n", data, err)
+	}
+}
+
+func TestRemoteExtractToContext(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "cheerio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DefaultClient.RemoteExtractTo(ctx, server.URL+"/foo-1.0.tar.gz", dir, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo-1.0")); !os.IsNotExist(err) {
+		t.Errorf("want nothing extracted after the context is done")
+	}
+}
@@ -1,119 +1,196 @@
 package fetch
 
 import (
-	"archive/tar"
 	"archive/zip"
-	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
 	"regexp"
+	"strings"
 )
 
+// The kind of archive to read. Archive formats are detected from their contents (see DetectCompression), so this is only a hint: a Zip is
+// first read with Range requests (see ranges.go), whereas a Tar is always read in full.
 type CompressionType string
 
 const (
 	Zip CompressionType = "zip"
-	Tar                 = "tar"
+	Tar CompressionType = "tar"
 )
 
+// Downloads an archive and returns the contents of the files that match pattern, concatenated in archive order. Kept for compatibility; use
+// the Client methods, which take a context and hashes to check.
 func RemoteDecompress(uri string, pattern *regexp.Regexp, compressType CompressionType) ([]byte, error) {
-	switch compressType {
-	case Zip:
-		return remoteUnzip(uri, pattern)
-	case Tar:
-		return remoteUntar(uri, pattern)
+	return DefaultClient.RemoteDecompress(context.Background(), uri, pattern, compressType, nil)
+}
+
+// Downloads an archive and returns the contents of the files that match pattern, concatenated in archive order. The archive is checked
+// against the given hashes, if any; a *HashMismatchError is returned if they don't match. Returns a *NoMatchError if no file matches. The
+// client gives up when ctx is done: its cancellation and deadline apply to the requests, the waits between retries, and reading the archive.
+func (c *Client) RemoteDecompress(ctx context.Context, uri string, pattern *regexp.Regexp, compressType CompressionType, hashes Hashes) ([]byte, error) {
+	data, err := c.RemoteDecompressPatterns(ctx, uri, []*regexp.Regexp{pattern}, compressType, hashes)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Unrecognized compression type: %s", compressType)
+	if data[0] == nil {
+		return nil, &NoMatchError{Pattern: pattern}
+	}
+	return data[0], nil
 }
 
-func remoteUntar(uri string, pattern *regexp.Regexp) ([]byte, error) {
-	resp, err := http.Get(uri)
+// Like RemoteDecompress, but extracts the files that match each of several patterns from a single download of the archive. The result has an
+// entry for each pattern, holding the contents of the matching files concatenated in archive order, or nil if no file matched.
+func (c *Client) RemoteDecompressPatterns(ctx context.Context, uri string, patterns []*regexp.Regexp, compressType CompressionType, hashes Hashes) ([][]byte, error) {
+	var alternatives []string
+	for _, pattern := range patterns {
+		alternatives = append(alternatives, "(?:"+pattern.String()+")")
+	}
+	files, err := c.remoteExtract(ctx, uri, regexp.MustCompile(strings.Join(alternatives, "|")), compressType, hashes)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var decompressed io.Reader
-	if filepath.Ext(uri) == ".bz2" {
-		decompressed = bzip2.NewReader(resp.Body)
-	} else {
-		decompressed, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
+	data := make([][]byte, len(patterns))
+	for i, pattern := range patterns {
+		for _, file := range files {
+			if pattern.MatchString(file.name) {
+				// Non-nil even if the file is empty, so that an empty match can be told apart from no match
+				if data[i] == nil {
+					data[i] = []byte{}
+				}
+				data[i] = append(data[i], file.data...)
+			}
 		}
 	}
+	return data, nil
+}
 
-	tr := tar.NewReader(decompressed)
-	var data []byte
-	matched := false
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		} else if hdr == nil {
-			return nil, fmt.Errorf("Error untarring %s: nil header (may be malformed)", uri)
-		}
+// The files extracted from an archive, keyed by their path in the archive.
+type Files map[string][]byte
 
-		if pattern.MatchString(hdr.Name) {
-			buf := bytes.NewBuffer(make([]byte, 0, hdr.Size))
-			io.Copy(buf, tr)
-			data = append(data, buf.Bytes()...)
-			matched = true
-		}
-	}
-	if !matched {
-		return nil, fmt.Errorf("No file matched pattern %+v", pattern)
+// Extracts the files that match pattern from an archive, as RemoteDecompress does. Unlike RemoteDecompress, the files are kept apart, so
+// callers can tell which is which when several match (e.g., the PKG-INFO of a package and those of packages vendored in it). Returns an empty
+// map if no file matches.
+func (c *Client) RemoteExtract(ctx context.Context, uri string, pattern *regexp.Regexp, compressType CompressionType, hashes Hashes) (Files, error) {
+	files, err := c.remoteExtract(ctx, uri, pattern, compressType, hashes)
+	if err != nil {
+		return nil, err
 	}
+	return filesByName(files), nil
+}
 
-	return data, nil
+// A file extracted from an archive.
+type archiveFile struct {
+	name string
+	data []byte
 }
 
-func remoteUnzip(uri string, pattern *regexp.Regexp) ([]byte, error) {
-	resp, err := http.Get(uri)
+// Extracts the files that match pattern, in archive order.
+func (c *Client) remoteExtract(ctx context.Context, uri string, pattern *regexp.Regexp, compressType CompressionType, hashes Hashes) ([]*archiveFile, error) {
+	var files []*archiveFile
+	err := c.Retry(ctx, uri, func() error {
+		v := newVerifier(uri, hashes)
+		lim := c.limits()
+		var err error
+		switch compressType {
+		case Zip:
+			files, err = c.remoteUnzip(ctx, uri, pattern, v, lim)
+		case Tar:
+			files, err = c.remoteDownloadExtract(ctx, uri, pattern, v, lim)
+		default:
+			err = fmt.Errorf("Unrecognized compression type: %s", compressType)
+		}
+		return err
+	})
+	return files, err
+}
+
+// Returned when no file in an archive matches the pattern.
+type NoMatchError struct {
+	Pattern *regexp.Regexp
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("No file matched pattern %+v", e.Pattern)
+}
+
+// Downloads a whole archive and extracts from it, whatever its format.
+func (c *Client) remoteDownloadExtract(ctx context.Context, uri string, pattern *regexp.Regexp, v *verifier, lim Limits) ([]*archiveFile, error) {
+	resp, err := c.Get(ctx, uri)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-
-	zipdata, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if err := CheckStatus(resp); err != nil {
 		return nil, err
 	}
+	return extractVerified(uri, resp, pattern, v, lim)
+}
 
-	zr, err := zip.NewReader(bytes.NewReader(zipdata), resp.ContentLength)
-	if err != nil {
+// Extracts from a whole archive sent in a response, checking it against the verifier and the limits.
+func extractVerified(uri string, resp *http.Response, pattern *regexp.Regexp, v *verifier, lim Limits) ([]*archiveFile, error) {
+	if err := checkLimit(uri, ArchiveSizeLimit, resp.ContentLength, lim.MaxArchiveSize); err != nil {
+		return nil, err
+	}
+	limited := limitReader(resp.Body, uri, ArchiveSizeLimit, lim.MaxArchiveSize)
+	body := v.reader(limited)
+	files, err := extractStream(uri, body, pattern, lim)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
 		return nil, err
+	} else if limitErr := exceeded(limited); limitErr != nil {
+		return nil, limitErr
 	}
 
-	var data []byte
-	matched := false
-	for _, file := range zr.File {
-		if file == nil {
-			return nil, fmt.Errorf("Error unzipping %s: nil file (may be malformed)", uri)
+	// The whole archive must be read for its hash to be checked, and a mismatch explains any error extracting it
+	if v != nil {
+		if _, copyErr := io.Copy(ioutil.Discard, body); copyErr != nil && err == nil {
+			err = copyErr
 		}
-
-		if pattern.MatchString(file.Name) {
-			fr, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
-			defer fr.Close()
-			filedata, err := ioutil.ReadAll(fr)
-			if err != nil {
-				return nil, err
-			}
-			data = append(data, filedata...)
-			matched = true
+		if limitErr := exceeded(limited); limitErr != nil {
+			return nil, limitErr
+		}
+		if verifyErr := v.verify(); verifyErr != nil && !IsTransient(err) {
+			return nil, verifyErr
 		}
 	}
-	if !matched {
-		return nil, fmt.Errorf("No file matched pattern %+v", pattern)
-	}
+	return files, err
+}
 
-	return data, nil
+// Extracts from an archive stream, detecting its format from its magic bytes rather than trusting its filename.
+func extractStream(uri string, body io.Reader, pattern *regexp.Regexp, lim Limits) ([]*archiveFile, error) {
+	col := &collector{uri: uri, pattern: pattern, lim: lim}
+	err := walkStream(uri, body, lim, col.visit)
+	return col.files, err
+}
+
+// Reads a zip with Range requests if possible (see ranges.go), and otherwise downloads all of it.
+func (c *Client) remoteUnzip(ctx context.Context, uri string, pattern *regexp.Regexp, v *verifier, lim Limits) ([]*archiveFile, error) {
+	if v == nil || c.UnverifiedRangeReads {
+		ra, resp, err := c.openRange(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		if ra == nil {
+			// The server sent the whole archive
+			defer resp.Body.Close()
+			return extractVerified(uri, resp, pattern, v, lim)
+		}
+		if err := checkLimit(uri, ArchiveSizeLimit, ra.size, lim.MaxArchiveSize); err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(ra, ra.size)
+		if err == nil {
+			col := &collector{uri: uri, pattern: pattern, lim: lim}
+			err := walkZip(uri, zr, lim, col.visit)
+			return col.files, err
+		} else if err != zip.ErrFormat {
+			return nil, fmt.Errorf("Error unzipping %s: %w", uri, err)
+		}
+		// Not a zip after all (e.g., a mislabeled tarball), so read it in full
+	}
+	return c.remoteDownloadExtract(ctx, uri, pattern, v, lim)
 }
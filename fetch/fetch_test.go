@@ -0,0 +1,143 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// Builds an uncompressed tar archive containing the given files.
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// Builds a gzipped tar archive containing the given files.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(tarball(t, files))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRemoteDecompressHashes(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(archive)
+	good := Hashes{"sha256": hex.EncodeToString(sum[:])}
+	bad := Hashes{"sha256": hex.EncodeToString(make([]byte, sha256.Size))}
+	pattern := regexp.MustCompile(`PKG-INFO`)
+	uri := server.URL + "/foo-1.0.tar.gz"
+
+	data, err := DefaultClient.RemoteDecompress(context.Background(), uri, pattern, Tar, good)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "Name: foo\n" {
+		t.Errorf("unexpected data %q", data)
+	}
+
+	_, err = DefaultClient.RemoteDecompress(context.Background(), uri, pattern, Tar, bad)
+	if mismatch, ok := err.(*HashMismatchError); !ok {
+		t.Errorf("want *HashMismatchError, got %v", err)
+	} else if mismatch.Algorithm != "sha256" || mismatch.Actual != good["sha256"] {
+		t.Errorf("unexpected mismatch %+v", mismatch)
+	}
+
+	// Hashes that can't be checked don't stop the download
+	if data, err := DefaultClient.RemoteDecompress(context.Background(), uri, pattern, Tar, Hashes{"blake2b_256": "00"}); err != nil || string(data) != "Name: foo\n" {
+		t.Errorf("want the data without verifying unsupported hashes, got %q (%v)", data, err)
+	}
+}
+
+func TestRemoteDecompressPatterns(t *testing.T) {
+	archive := tarGz(t, map[string]string{
+		"foo-1.0/PKG-INFO":                   "Name: foo\n",
+		"foo-1.0/foo.egg-info/PKG-INFO":      "Name: foo\n",
+		"foo-1.0/foo.egg-info/requires.txt":  "",
+		"foo-1.0/foo.egg-info/top_level.txt": "foo\n",
+	})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`PKG-INFO$`),
+		regexp.MustCompile(`requires\.txt$`),
+		regexp.MustCompile(`top_level\.txt$`),
+		regexp.MustCompile(`SOURCES\.txt$`),
+	}
+	data, err := DefaultClient.RemoteDecompressPatterns(context.Background(), server.URL+"/foo-1.0.tar.gz", patterns, Tar, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("want 1 request, got %d", requests)
+	}
+	if string(data[0]) != "Name: foo\nName: foo\n" {
+		t.Errorf("PKG-INFO: want both files concatenated, got %q", data[0])
+	}
+	if data[1] == nil || len(data[1]) != 0 {
+		t.Errorf("requires.txt: want empty, non-nil match, got %#v", data[1])
+	}
+	if string(data[2]) != "foo\n" {
+		t.Errorf("top_level.txt: want %q, got %q", "foo\n", data[2])
+	}
+	if data[3] != nil {
+		t.Errorf("SOURCES.txt: want nil for no match, got %q", data[3])
+	}
+
+	if _, err := RemoteDecompress(server.URL+"/foo-1.0.tar.gz", patterns[3], Tar); err == nil {
+		t.Errorf("want *NoMatchError, got nil")
+	} else if _, ok := err.(*NoMatchError); !ok {
+		t.Errorf("want *NoMatchError, got %v", err)
+	}
+}
+
+func TestRemoteExtract(t *testing.T) {
+	archive := tarGz(t, map[string]string{
+		"foo-1.0/PKG-INFO":                "Name: foo\n",
+		"foo-1.0/vendor/bar-2.0/PKG-INFO": "Name: bar\n",
+		"foo-1.0/setup.py":                "",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	files, err := DefaultClient.RemoteExtract(context.Background(), server.URL+"/foo-1.0.tar.gz", regexp.MustCompile(`PKG-INFO$`), Tar, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Files{"foo-1.0/PKG-INFO": []byte("Name: foo\n"), "foo-1.0/vendor/bar-2.0/PKG-INFO": []byte("Name: bar\n")}
+	if len(files) != len(want) {
+		t.Errorf("want %d files, got %d", len(want), len(files))
+	}
+	for name, data := range want {
+		if !bytes.Equal(files[name], data) {
+			t.Errorf("%s: want %q, got %q", name, data, files[name])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package fetch
+
+import (
+	"fmt"
+	"io"
+)
+
+// Limits on the archives a Client reads, to protect against huge downloads and decompression bombs from untrusted indexes. In a Client's Limits,
+// a zero field means the default limit, and a negative one means no limit.
+type Limits struct {
+	MaxArchiveSize int64 // compressed bytes of an archive
+	MaxFileSize    int64 // decompressed bytes of each extracted file
+	MaxTotalSize   int64 // decompressed bytes of an archive's contents
+	MaxFiles       int   // members of an archive
+}
+
+var DefaultLimits = Limits{
+	MaxArchiveSize: 2 << 30,
+	MaxFileSize:    64 << 20,
+	MaxTotalSize:   4 << 30,
+	MaxFiles:       100000,
+}
+
+// The limit that an archive exceeded.
+type LimitKind string
+
+const (
+	ArchiveSizeLimit LimitKind = "archive size"
+	FileSizeLimit    LimitKind = "file size"
+	TotalSizeLimit   LimitKind = "total size"
+	FileCountLimit   LimitKind = "file count"
+)
+
+// Returned when an archive exceeds one of the Client's Limits.
+type LimitError struct {
+	URI   string
+	Limit LimitKind
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeds the %s limit of %d", e.URI, e.Limit, e.Max)
+}
+
+// Returns the limits in effect for the client, with defaults filled in.
+func (c *Client) limits() Limits {
+	if c.Limits == nil {
+		return DefaultLimits
+	}
+	l := *c.Limits
+	if l.MaxArchiveSize == 0 {
+		l.MaxArchiveSize = DefaultLimits.MaxArchiveSize
+	}
+	if l.MaxFileSize == 0 {
+		l.MaxFileSize = DefaultLimits.MaxFileSize
+	}
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	if l.MaxFiles == 0 {
+		l.MaxFiles = DefaultLimits.MaxFiles
+	}
+	return l
+}
+
+// Returns a *LimitError if n exceeds max, unless max is negative (i.e., there is no limit).
+func checkLimit(uri string, kind LimitKind, n, max int64) error {
+	if max >= 0 && n > max {
+		return &LimitError{URI: uri, Limit: kind, Max: max}
+	}
+	return nil
+}
+
+// A reader that fails with a *LimitError once more than max bytes have been read from it.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	err       *LimitError
+}
+
+// Limits the bytes read from r, unless max is negative.
+func limitReader(r io.Reader, uri string, kind LimitKind, max int64) io.Reader {
+	if max < 0 {
+		return r
+	}
+	return &limitedReader{r: r, remaining: max, err: &LimitError{URI: uri, Limit: kind, Max: max}}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	// Read one byte past the limit, to tell a stream that ends at the limit from one that goes past it
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+// Returns the *LimitError of r if it is a reader returned by limitReader whose limit was exceeded, and nil otherwise. Decompressors don't always
+// pass on the errors of the readers under them, so this tells whether an error extracting an archive was caused by a limit.
+func exceeded(r io.Reader) error {
+	if l, ok := r.(*limitedReader); ok && l.remaining < 0 {
+		return l.err
+	}
+	return nil
+}
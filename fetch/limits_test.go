@@ -0,0 +1,136 @@
+package fetch
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestLimits(t *testing.T) {
+	files := map[string]string{
+		"foo-1.0/PKG-INFO":     "Name: foo\n",
+		"foo-1.0/foo/big.bin":  strings.Repeat("\x00", 1<<20), // compresses to almost nothing
+		"foo-1.0/foo/__init__": "",
+	}
+	archives := map[string][]byte{"foo-1.0.tar.gz": tarGz(t, files), "foo-1.0.zip": zipArchive(t, files)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		archive := archives[strings.TrimPrefix(r.URL.Path, "/")]
+		if r.URL.Query().Get("stream") != "" {
+			// Flushing before writing the body leaves out Content-Length, so the size is only found by reading
+			w.(http.Flusher).Flush()
+			w.Write(archive)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(archive))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		query   string
+		pattern string
+		limits  Limits
+		want    LimitKind // empty if the archive is within the limits
+	}{
+		{name: "defaults", pattern: `PKG-INFO`},
+		{name: "no limits", pattern: `\.bin$`, limits: Limits{MaxArchiveSize: -1, MaxFileSize: -1, MaxTotalSize: -1, MaxFiles: -1}},
+		{name: "archive size", pattern: `PKG-INFO`, limits: Limits{MaxArchiveSize: 100}, want: ArchiveSizeLimit},
+		{name: "archive size, streamed", query: "?stream=1", pattern: `PKG-INFO`, limits: Limits{MaxArchiveSize: 100}, want: ArchiveSizeLimit},
+		{name: "file size", pattern: `\.bin$`, limits: Limits{MaxFileSize: 1 << 10}, want: FileSizeLimit},
+		{name: "unextracted file size", pattern: `PKG-INFO`, limits: Limits{MaxFileSize: 1 << 10}},
+		{name: "total size", pattern: `PKG-INFO`, limits: Limits{MaxTotalSize: 1 << 10}, want: TotalSizeLimit},
+		{name: "file count", pattern: `PKG-INFO`, limits: Limits{MaxFiles: 2}, want: FileCountLimit},
+		{name: "exact file count", pattern: `PKG-INFO`, limits: Limits{MaxFiles: 3}},
+	}
+	for _, filename := range []string{"foo-1.0.tar.gz", "foo-1.0.zip"} {
+		for _, test := range tests {
+			c := &Client{Limits: &test.limits}
+			uri := server.URL + "/" + filename + test.query
+			ct := Tar
+			if strings.HasSuffix(filename, ".zip") {
+				ct = Zip
+			}
+			_, err := c.RemoteExtract(context.Background(), uri, regexp.MustCompile(test.pattern), ct, nil)
+
+			var limitErr *LimitError
+			if test.want == "" {
+				if err != nil {
+					t.Errorf("%s, %s: want no error, got %v", filename, test.name, err)
+				}
+			} else if !errors.As(err, &limitErr) {
+				t.Errorf("%s, %s: want *LimitError, got %v", filename, test.name, err)
+			} else if limitErr.Limit != test.want || limitErr.URI != uri {
+				t.Errorf("%s, %s: want %s limit of %s, got %+v", filename, test.name, test.want, uri, limitErr)
+			}
+		}
+	}
+}
+
+func TestLimitsWithHashes(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": strings.Repeat("Name: foo\n", 1000)})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	// The archive is cut short at the limit, so its hash can't match, but the limit is what explains that
+	sum := sha256.Sum256(archive)
+	hashes := Hashes{"sha256": hex.EncodeToString(sum[:])}
+	c := &Client{Limits: &Limits{MaxArchiveSize: 64}}
+	_, err := c.RemoteExtract(context.Background(), server.URL+"/foo-1.0.tar.gz", regexp.MustCompile(`PKG-INFO`), Tar, hashes)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != ArchiveSizeLimit {
+		t.Errorf("want *LimitError for the archive size, got %v", err)
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	for _, test := range []struct {
+		size, max int64
+		exceeded  bool
+	}{
+		{size: 10, max: 10},
+		{size: 11, max: 10, exceeded: true},
+		{size: 0, max: 0},
+		{size: 1, max: 0, exceeded: true},
+		{size: 100, max: -1},
+	} {
+		r := limitReader(bytes.NewReader(make([]byte, test.size)), "uri", FileSizeLimit, test.max)
+		data, err := ioutil.ReadAll(r)
+		if test.exceeded {
+			if _, ok := err.(*LimitError); !ok || exceeded(r) == nil {
+				t.Errorf("%d bytes with limit %d: want *LimitError, got %v", test.size, test.max, err)
+			}
+			if int64(len(data)) > test.max+1 {
+				t.Errorf("%d bytes with limit %d: read %d bytes", test.size, test.max, len(data))
+			}
+		} else if err != nil || int64(len(data)) != test.size || exceeded(r) != nil {
+			t.Errorf("%d bytes with limit %d: want all of them, got %d and error %v", test.size, test.max, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"os"
+	"regexp"
+)
+
+// Equivalents of RemoteDecompress and RemoteExtract for archives that are already at hand, such as sdists in the pip cache or a mirror. Their
+// formats are detected from their contents, and the Client's limits apply to them as to downloads.
+
+// Stands in for the URI of an archive read from an io.Reader or io.ReaderAt, in errors.
+const readerURI = "archive"
+
+// Like RemoteDecompress, but reads the archive from r. A zip is copied to a temporary file to be read, so use DecompressAt for zips that can
+// be read at random.
+func (c *Client) Decompress(ctx context.Context, r io.Reader, pattern *regexp.Regexp) ([]byte, error) {
+	files, err := c.extractReader(ctx, r, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return concatMatches(files, pattern)
+}
+
+// Like RemoteDecompress, but reads the archive of the given size from r.
+func (c *Client) DecompressAt(ctx context.Context, r io.ReaderAt, size int64, pattern *regexp.Regexp) ([]byte, error) {
+	files, err := c.extractAt(ctx, readerURI, r, size, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return concatMatches(files, pattern)
+}
+
+// Like RemoteDecompress, but reads the archive at a local path.
+func (c *Client) DecompressFile(ctx context.Context, path string, pattern *regexp.Regexp) ([]byte, error) {
+	files, err := c.extractFile(ctx, path, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return concatMatches(files, pattern)
+}
+
+// Like RemoteExtract, but reads the archive from r.
+func (c *Client) Extract(ctx context.Context, r io.Reader, pattern *regexp.Regexp) (Files, error) {
+	files, err := c.extractReader(ctx, r, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return filesByName(files), nil
+}
+
+// Like RemoteExtract, but reads the archive of the given size from r.
+func (c *Client) ExtractAt(ctx context.Context, r io.ReaderAt, size int64, pattern *regexp.Regexp) (Files, error) {
+	files, err := c.extractAt(ctx, readerURI, r, size, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return filesByName(files), nil
+}
+
+// Like RemoteExtract, but reads the archive at a local path.
+func (c *Client) ExtractFile(ctx context.Context, path string, pattern *regexp.Regexp) (Files, error) {
+	files, err := c.extractFile(ctx, path, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return filesByName(files), nil
+}
+
+func (c *Client) extractReader(ctx context.Context, r io.Reader, pattern *regexp.Regexp) ([]*archiveFile, error) {
+	lim := c.limits()
+	limited := limitReader(&contextReader{Reader: r, ctx: ctx}, readerURI, ArchiveSizeLimit, lim.MaxArchiveSize)
+	files, err := extractStream(readerURI, limited, pattern, lim)
+	if limitErr := exceeded(limited); limitErr != nil {
+		return nil, limitErr
+	}
+	return files, err
+}
+
+func (c *Client) extractAt(ctx context.Context, uri string, r io.ReaderAt, size int64, pattern *regexp.Regexp) ([]*archiveFile, error) {
+	lim := c.limits()
+	col := &collector{uri: uri, pattern: pattern, lim: lim}
+	err := walkReaderAt(uri, &contextReaderAt{ReaderAt: r, ctx: ctx}, size, lim, col.visit)
+	return col.files, err
+}
+
+func (c *Client) extractFile(ctx context.Context, path string, pattern *regexp.Regexp) ([]*archiveFile, error) {
+	f, size, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.extractAt(ctx, path, f, size, pattern)
+}
+
+// An io.Reader whose reads fail once a context is done, like contextBody.
+type contextReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+// An io.ReaderAt whose reads fail once a context is done, like contextBody. Every member of an archive is read through it, so walking the
+// archive stops at the next read.
+type contextReaderAt struct {
+	io.ReaderAt
+	ctx context.Context
+}
+
+func (r *contextReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// Opens a local archive and returns its size.
+func openArchive(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Concatenates the contents of the extracted files in archive order, as RemoteDecompress does. Returns a *NoMatchError if there are none.
+func concatMatches(files []*archiveFile, pattern *regexp.Regexp) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, &NoMatchError{Pattern: pattern}
+	}
+	data := []byte{}
+	for _, file := range files {
+		data = append(data, file.data...)
+	}
+	return data, nil
+}
+
+func filesByName(files []*archiveFile) Files {
+	extracted := make(Files, len(files))
+	for _, file := range files {
+		extracted[file.name] = file.data
+	}
+	return extracted
+}
@@ -0,0 +1,91 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLocalArchives(t *testing.T) {
+	files := map[string]string{
+		"foo-1.0/PKG-INFO":                "Name: foo\n",
+		"foo-1.0/vendor/bar-2.0/PKG-INFO": "Name: bar\n",
+		"foo-1.0/setup.py":                "",
+	}
+	dir, err := ioutil.TempDir("", "cheerio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pattern := regexp.MustCompile(`PKG-INFO$`)
+	for filename, archive := range map[string][]byte{"foo-1.0.tar.gz": tarGz(t, files), "foo-1.0.zip": zipArchive(t, files)} {
+		path := filepath.Join(dir, filename)
+		if err := ioutil.WriteFile(path, archive, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		extracts := map[string]func() (Files, error){
+			"Extract": func() (Files, error) {
+				return DefaultClient.Extract(context.Background(), bytes.NewReader(archive), pattern)
+			},
+			"ExtractAt": func() (Files, error) {
+				return DefaultClient.ExtractAt(context.Background(), bytes.NewReader(archive), int64(len(archive)), pattern)
+			},
+			"ExtractFile": func() (Files, error) { return DefaultClient.ExtractFile(context.Background(), path, pattern) },
+		}
+		for name, extract := range extracts {
+			got, err := extract()
+			if err != nil {
+				t.Errorf("%s, %s: %v", filename, name, err)
+			} else if len(got) != 2 || string(got["foo-1.0/PKG-INFO"]) != "Name: foo\n" || string(got["foo-1.0/vendor/bar-2.0/PKG-INFO"]) != "Name: bar\n" {
+				t.Errorf("%s, %s: unexpected files %q", filename, name, got)
+			}
+		}
+
+		decompresses := map[string]func(*regexp.Regexp) ([]byte, error){
+			"Decompress": func(p *regexp.Regexp) ([]byte, error) {
+				return DefaultClient.Decompress(context.Background(), bytes.NewReader(archive), p)
+			},
+			"DecompressAt": func(p *regexp.Regexp) ([]byte, error) {
+				return DefaultClient.DecompressAt(context.Background(), bytes.NewReader(archive), int64(len(archive)), p)
+			},
+			"DecompressFile": func(p *regexp.Regexp) ([]byte, error) {
+				return DefaultClient.DecompressFile(context.Background(), path, p)
+			},
+		}
+		for name, decompress := range decompresses {
+			if data, err := decompress(regexp.MustCompile(`^foo-1\.0/PKG-INFO$`)); err != nil {
+				t.Errorf("%s, %s: %v", filename, name, err)
+			} else if string(data) != "Name: foo\n" {
+				t.Errorf("%s, %s: want %q, got %q", filename, name, "Name: foo\n", data)
+			}
+			if data, err := decompress(regexp.MustCompile(`setup\.py$`)); err != nil || data == nil || len(data) != 0 {
+				t.Errorf("%s, %s: want empty, non-nil match, got %#v (%v)", filename, name, data, err)
+			}
+			if _, err := decompress(regexp.MustCompile(`requires\.txt$`)); err == nil {
+				t.Errorf("%s, %s: want *NoMatchError, got nil", filename, name)
+			} else if _, ok := err.(*NoMatchError); !ok {
+				t.Errorf("%s, %s: want *NoMatchError, got %v", filename, name, err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := DefaultClient.ExtractFile(ctx, path, pattern); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s, ExtractFileContext: want context.Canceled, got %v", filename, err)
+		}
+
+		out := filepath.Join(dir, filename+".out")
+		if err := DefaultClient.ExtractFileTo(context.Background(), path, out); err != nil {
+			t.Errorf("%s, ExtractFileTo: %v", filename, err)
+		} else if data, err := ioutil.ReadFile(filepath.Join(out, "foo-1.0/vendor/bar-2.0/PKG-INFO")); err != nil || string(data) != "Name: bar\n" {
+			t.Errorf("%s, ExtractFileTo: want %q, got %q (%v)", filename, "Name: bar\n", data, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Zip archives keep their directory at the end, so a zip can be read without downloading all of it: one Range request for its tail finds the
+// directory, and further requests read only the members that are needed. This only works if the server supports Range requests, and it means
+// the archive can't be checked against its hashes, so it is only done when there are no hashes to check (or Client.UnverifiedRangeReads is
+// set).
+
+const (
+	minReadAhead = 64 << 10
+	maxReadAhead = 4 << 20
+)
+
+// An io.ReaderAt over a remote file, read with HTTP Range requests.
+type rangeReader struct {
+	ctx  context.Context
+	c    *Client
+	uri  string
+	size int64
+
+	mu        sync.Mutex
+	buf       []byte // the bytes of the file starting at bufOff, from the last request
+	bufOff    int64
+	readAhead int // bytes to request past what is asked for; grows while reads are sequential
+}
+
+// Requests the tail of a file, which holds the directory of a zip. If the server answers with a partial response, returns a *rangeReader for the
+// file. If it ignores the Range header and sends the whole file, returns that response instead; the caller must close its body.
+func (c *Client) openRange(ctx context.Context, uri string) (*rangeReader, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=-%d", minReadAhead))
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		if err := CheckStatus(resp); err != nil {
+			resp.Body.Close()
+			return nil, nil, err
+		}
+		return nil, resp, nil
+	}
+	defer resp.Body.Close()
+
+	start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading %s: %w", uri, err)
+	}
+	tail, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rangeReader{ctx: ctx, c: c, uri: uri, size: size, buf: tail, bufOff: start, readAhead: minReadAhead}, nil, nil
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		if pos < r.bufOff || pos >= r.bufOff+int64(len(r.buf)) {
+			if err := r.fill(pos, len(p)-n); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.buf[pos-r.bufOff:])
+	}
+	return n, nil
+}
+
+// Replaces the buffer with the bytes starting at off: at least want of them, plus the read-ahead.
+func (r *rangeReader) fill(off int64, want int) error {
+	if off == r.bufOff+int64(len(r.buf)) && r.readAhead < maxReadAhead {
+		r.readAhead *= 2
+	} else if off != r.bufOff+int64(len(r.buf)) {
+		r.readAhead = minReadAhead
+	}
+	end := off + int64(want+r.readAhead) - 1
+	if end >= r.size {
+		end = r.size - 1
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx, "GET", r.uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	resp, err := r.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		if err := CheckStatus(resp); err != nil {
+			return err
+		}
+		return fmt.Errorf("Error reading %s: server stopped honoring Range requests", r.uri)
+	}
+	if start, size, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+		return fmt.Errorf("Error reading %s: %w", r.uri, err)
+	} else if start != off || size != r.size {
+		return fmt.Errorf("Error reading %s: unexpected Content-Range %q", r.uri, resp.Header.Get("Content-Range"))
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	r.buf, r.bufOff = buf, off
+	return nil
+}
+
+// Parses a Content-Range header of the form "bytes <start>-<end>/<size>".
+func parseContentRange(value string) (start, size int64, err error) {
+	spec := strings.TrimPrefix(value, "bytes ")
+	slash, dash := strings.IndexByte(spec, '/'), strings.IndexByte(spec, '-')
+	if spec == value || slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", value)
+	}
+	if start, err = strconv.ParseInt(spec[:dash], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", value)
+	}
+	if size, err = strconv.ParseInt(spec[slash+1:], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("bad Content-Range %q (unknown size)", value)
+	}
+	return start, size, nil
+}
@@ -0,0 +1,101 @@
+package fetch
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Counts the bytes of response bodies written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	*w.n += int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestRangeUnzip(t *testing.T) {
+	// A large, incompressible member that should not be downloaded, and a small one that should
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	filler := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(filler)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "foo/data.bin", Method: zip.Store})
+	w.Write(filler)
+	w, _ = zw.Create("EGG-INFO/PKG-INFO")
+	w.Write([]byte("Name: foo\n"))
+	zw.Close()
+	archive := buf.Bytes()
+	sum := sha256.Sum256(archive)
+	hashes := Hashes{"sha256": hex.EncodeToString(sum[:])}
+	pattern := regexp.MustCompile(`PKG-INFO`)
+
+	var served int64
+	ranges := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(countingWriter{w, &served}, r, "foo-1.0.egg", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer server.Close()
+	uri := server.URL + "/foo-1.0.egg"
+
+	tests := []struct {
+		name    string
+		ranges  bool
+		client  *Client
+		hashes  Hashes
+		partial bool
+	}{
+		{"no hashes", true, &Client{}, nil, true},
+		{"no range support", false, &Client{}, nil, false},
+		{"hashes", true, &Client{}, hashes, false},
+		{"unverified range reads", true, &Client{UnverifiedRangeReads: true}, hashes, true},
+	}
+	for _, test := range tests {
+		served, ranges = 0, test.ranges
+		data, err := test.client.RemoteDecompress(context.Background(), uri, pattern, Zip, test.hashes)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if string(data) != "Name: foo\n" {
+			t.Errorf("%s: unexpected data %q", test.name, data)
+		}
+		if partial := served < int64(len(archive))/4; partial != test.partial {
+			t.Errorf("%s: want partial download %v, got %d of %d bytes", test.name, test.partial, served, len(archive))
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value       string
+		start, size int64
+		wantErr     bool
+	}{
+		{"bytes 0-99/200", 0, 200, false},
+		{"bytes 100-199/200", 100, 200, false},
+		{"bytes 0-99/*", 0, 0, true},
+		{"0-99/200", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, test := range tests {
+		start, size, err := parseContentRange(test.value)
+		if (err != nil) != test.wantErr || start != test.start || size != test.size {
+			t.Errorf("%q: want %d, %d (error: %v), got %d, %d (error: %v)", test.value, test.start, test.size, test.wantErr, start, size, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// How a Client retries requests that fail transiently: with 429 or 5xx responses, connection resets or timeouts. Retries back off
+// exponentially, with jitter, starting from MinBackoff. A Retry-After header overrides the backoff.
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt; zero disables retrying
+	MinBackoff time.Duration // backoff before the first retry, doubled for each retry after that
+	MaxBackoff time.Duration // cap on the backoff, including Retry-After waits
+}
+
+// The RetryPolicy used when a Client doesn't specify one.
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 4, MinBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+
+// Returned for responses with a status other than 200 OK.
+type StatusError struct {
+	URI        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unexpected status fetching %s: %s", e.URI, e.Status)
+}
+
+// Returns a *StatusError if the response status isn't 200 OK.
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return &StatusError{
+		URI:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// Calls op until it succeeds, fails with an error that isn't transient, or the retry budget runs out, and stops once ctx is done, including
+// while waiting to retry. Each call should make a new request with ctx (e.g., with Get) and read the whole response, so that errors reading the
+// body are retried too. The uri is only used for reporting retries.
+func (c *Client) Retry(ctx context.Context, uri string, op func() error) error {
+	policy := DefaultRetryPolicy
+	if c.RetryPolicy != nil {
+		policy = *c.RetryPolicy
+	}
+
+	backoff := policy.MinBackoff
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := op()
+		// A request that timed out because ctx did looks transient, but retrying it is pointless
+		if err == nil || attempt > policy.MaxRetries || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		// Wait between backoff/2 and backoff, unless the server asked for a specific wait
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		}
+		if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+			wait = policy.MaxBackoff
+		}
+		if c.OnRetry != nil {
+			c.OnRetry(uri, attempt, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if backoff *= 2; policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// Reports whether an error is likely to go away if the request is retried.
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Parses a Retry-After header, given either in seconds or as an HTTP date. Returns zero if it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"})
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/missing.tar.gz":
+			http.NotFound(w, r)
+		case attempts == 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case attempts == 2:
+			http.Error(w, "oops", http.StatusBadGateway)
+		default:
+			w.Write(archive)
+		}
+	}))
+	defer server.Close()
+
+	var retried []int
+	c := &Client{
+		RetryPolicy: &RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+		OnRetry:     func(uri string, attempt int, err error) { retried = append(retried, attempt) },
+	}
+	data, err := c.RemoteDecompress(context.Background(), server.URL+"/foo-1.0.tar.gz", regexp.MustCompile(`PKG-INFO`), Tar, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "Name: foo\n" {
+		t.Errorf("unexpected data %q", data)
+	}
+	if attempts != 3 || len(retried) != 2 {
+		t.Errorf("want 3 attempts and 2 retries, got %d and %v", attempts, retried)
+	}
+
+	attempts = 0
+	_, err = c.RemoteDecompress(context.Background(), server.URL+"/missing.tar.gz", regexp.MustCompile(`PKG-INFO`), Tar, nil)
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("want 404 *StatusError, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("want no retries for a 404, got %d attempts", attempts)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	archive := tarGz(t, map[string]string{"foo-1.0/PKG-INFO": "Name: foo\n"})
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch r.URL.Path {
+		case "/stalled.tar.gz":
+			// Send part of the archive, then nothing until the client gives up
+			w.Write(archive[:len(archive)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	c := &Client{RetryPolicy: &RetryPolicy{MaxRetries: 3, MinBackoff: time.Minute, MaxBackoff: time.Minute}}
+	pattern := regexp.MustCompile(`PKG-INFO`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.RemoteDecompress(ctx, server.URL+"/stalled.tar.gz", pattern, Tar, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want the stalled download to stop at the deadline, got %v", err)
+	}
+
+	// The wait before retrying is cut short too
+	attempts = 0
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.RemoteDecompress(ctx, server.URL+"/unavailable.tar.gz", pattern, Tar, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want the retries to stop at the deadline, got %v", err)
+	}
+	if attempts != 1 || time.Since(start) > 10*time.Second {
+		t.Errorf("want 1 attempt that returns at the deadline, got %d attempts in %s", attempts, time.Since(start))
+	}
+
+	attempts = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.RemoteDecompress(ctx, server.URL+"/unavailable.tar.gz", pattern, Tar, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if attempts != 0 {
+		t.Errorf("want no requests with a cancelled context, got %d", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("want 2m, got %s", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("want about 1h, got %s", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("want 0 for invalid header, got %s", got)
+	}
+}
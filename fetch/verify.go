@@ -0,0 +1,111 @@
+package fetch
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Expected digests of a file, keyed by hashlib algorithm name (e.g., "sha256"), with hex-encoded values. This is how PyPI publishes hashes, both
+// in URL fragments ("#sha256=...") and in the "hashes" field of the JSON simple API.
+type Hashes map[string]string
+
+var hashFuncs = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// Reports whether digests of the given algorithm can be checked. Indexes may publish others (e.g., blake2b_256), which are ignored.
+func SupportedHash(algorithm string) bool {
+	_, in := hashFuncs[strings.ToLower(algorithm)]
+	return in
+}
+
+// Reports whether any of the hashes can be checked.
+func (h Hashes) Verifiable() bool {
+	for algorithm := range h {
+		if SupportedHash(algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned when downloaded data does not match a digest published by the package index.
+type HashMismatchError struct {
+	URI       string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("%s hash mismatch for %s: expected %s, got %s", e.Algorithm, e.URI, e.Expected, e.Actual)
+}
+
+// Computes the digests of everything written to it and checks them against a set of expected hashes.
+type verifier struct {
+	uri      string
+	expected Hashes
+	hashes   map[string]hash.Hash
+}
+
+// Returns a verifier for the given expected hashes, or nil if there are none. Hashes of unsupported algorithms are ignored, so if none of them
+// is supported, the data is read unverified, as if the index had published no hashes.
+func newVerifier(uri string, expected Hashes) *verifier {
+	v := &verifier{uri: uri, expected: expected, hashes: make(map[string]hash.Hash)}
+	for algorithm := range expected {
+		if newHash, in := hashFuncs[strings.ToLower(algorithm)]; in {
+			v.hashes[algorithm] = newHash()
+		}
+	}
+	if len(v.hashes) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Wraps a reader so that everything read from it is hashed. A nil verifier returns the reader unchanged.
+func (v *verifier) reader(r io.Reader) io.Reader {
+	if v == nil {
+		return r
+	}
+	return io.TeeReader(r, v)
+}
+
+// Checks the digests of everything written so far. A nil verifier always succeeds.
+func (v *verifier) verify() error {
+	if v == nil {
+		return nil
+	}
+	algorithms := make([]string, 0, len(v.hashes))
+	for algorithm := range v.hashes {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		actual := hex.EncodeToString(v.hashes[algorithm].Sum(nil))
+		if expected := strings.ToLower(v.expected[algorithm]); actual != expected {
+			return &HashMismatchError{URI: v.uri, Algorithm: algorithm, Expected: expected, Actual: actual}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package cheerio
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// A PackageIndex does its I/O through a Fetcher: fetching the pages of the index and reading the archives of distribution files. The one
+// exception is a local index without a simple/ tree, which has no pages: its directory is listed from disk directly, and only its archives
+// are read through the Fetcher. The default is an HTTPFetcher; LocalFetcher serves an index from disk, CachedFetcher adds an on-disk cache
+// to another fetcher, and Recorder and Replayer record traffic and play it back, e.g., to run tests without a network. Fetchers should give
+// up once the given context is done.
+type Fetcher interface {
+	// Fetches a page of an index, asking for the given content types. If stale is non-nil, it is an earlier copy of the page, which may be
+	// revalidated with the server rather than fetched again. Statuses other than 200 OK are returned as a *fetch.StatusError.
+	FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error)
+
+	// Extracts the files that match pattern from the archive of a distribution file, checking the archive against the file's hashes, as
+	// fetch.RemoteExtract does.
+	ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error)
+
+	// Downloads the archive of a distribution file to a local path, checking it against the file's hashes, as fetch.Client.DownloadFile does.
+	DownloadFile(ctx context.Context, file *DistFile, path string) error
+}
+
+// A page of an index.
+type IndexPage struct {
+	URL          string // the final URL, after any redirects
+	ContentType  string
+	Body         []byte
+	ETag         string // validators, for revalidating the page
+	LastModified string
+	Fetched      time.Time
+}
+
+// Returns the fetcher for the index: its Fetcher if set, and otherwise an HTTPFetcher that adds the index's credentials, behind a CachedFetcher
+// if the index is cached.
+func (p *PackageIndex) fetcher() Fetcher {
+	if p.Fetcher != nil {
+		return p.Fetcher
+	}
+	client := p.client()
+	var f Fetcher = &HTTPFetcher{Client: client}
+	if c := p.cache(); c != nil {
+		f = &CachedFetcher{Fetcher: f, Cache: c, Client: client}
+	}
+	return f
+}
+
+// Returns the fetcher for the file's archive: that of the index that listed it, if any.
+func (f *DistFile) fetcher() Fetcher {
+	if f.Index == nil {
+		return &HTTPFetcher{}
+	}
+	return f.Index.fetcher()
+}
+
+// Fetches with a fetch.Client, over HTTP(S) or from file:// URLs. Failed requests are retried as the client's RetryPolicy says.
+type HTTPFetcher struct {
+	// If nil, fetch.DefaultClient is used.
+	Client *fetch.Client
+}
+
+func (h *HTTPFetcher) client() *fetch.Client {
+	if h.Client == nil {
+		return fetch.DefaultClient
+	}
+	return h.Client
+}
+
+func (h *HTTPFetcher) FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error) {
+	c := h.client()
+	var pg *IndexPage
+	err := c.Retry(ctx, uri, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", accept)
+		if stale != nil && stale.ETag != "" {
+			req.Header.Set("If-None-Match", stale.ETag)
+		}
+		if stale != nil && stale.LastModified != "" {
+			req.Header.Set("If-Modified-Since", stale.LastModified)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if stale != nil && resp.StatusCode == http.StatusNotModified {
+			revalidated := *stale
+			revalidated.Fetched = time.Now()
+			pg = &revalidated
+			return nil
+		}
+		if err := fetch.CheckStatus(resp); err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		pg = &IndexPage{
+			URL:          resp.Request.URL.String(),
+			ContentType:  resp.Header.Get("Content-Type"),
+			Body:         body,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Fetched:      time.Now(),
+		}
+		return nil
+	})
+	return pg, err
+}
+
+func (h *HTTPFetcher) ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	return h.client().RemoteExtract(ctx, file.URL, pattern, compressionType, file.Hashes)
+}
+
+func (h *HTTPFetcher) DownloadFile(ctx context.Context, file *DistFile, path string) error {
+	return h.client().DownloadFile(ctx, file.URL, path, file.Hashes)
+}
+
+// Serves an index from a directory, as a static web server would: the path of each URL, whatever its host, is looked up under Dir, and a
+// directory is served by its index.html. So the tree written by Mirror can stand in for the index it was mirrored from.
+type LocalFetcher struct {
+	Dir string
+
+	// Reads the archives. If nil, fetch.DefaultClient is used.
+	Client *fetch.Client
+}
+
+// Returns the client for reading archives under Dir.
+func (l *LocalFetcher) client() *fetch.Client {
+	if l.Client == nil {
+		return fileClient(fetch.DefaultClient)
+	}
+	return fileClient(l.Client)
+}
+
+// Returns a copy of a client that may read file:// URLs, for reading archives that are known to be local.
+func fileClient(base *fetch.Client) *fetch.Client {
+	c := *base
+	c.AllowFileURLs = true
+	return &c
+}
+
+// Returns the local path that a URL is served from.
+func (l *LocalFetcher) path(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(path.Clean("/"+u.Path))), nil
+}
+
+func (l *LocalFetcher) FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := l.path(uri)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		p = filepath.Join(p, "index.html")
+	}
+	body, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, &fetch.StatusError{URI: uri, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	} else if err != nil {
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(p))
+	if contentType == "" {
+		contentType = "text/html"
+	}
+	return &IndexPage{URL: uri, ContentType: contentType, Body: body, Fetched: time.Now()}, nil
+}
+
+func (l *LocalFetcher) ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	p, err := l.path(file.URL)
+	if err != nil {
+		return nil, err
+	}
+	return l.client().RemoteExtract(ctx, fileURL(p), pattern, compressionType, file.Hashes)
+}
+
+func (l *LocalFetcher) DownloadFile(ctx context.Context, file *DistFile, path string) error {
+	p, err := l.path(file.URL)
+	if err != nil {
+		return err
+	}
+	return l.client().DownloadFile(ctx, fileURL(p), path, file.Hashes)
+}
@@ -0,0 +1,189 @@
+package cheerio
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beyang/cheerio/fetch"
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{
+		{Version: "1.0", RequiresTxt: "bar>=2.0\n", TopLevelTxt: "foo\n"},
+	}})
+	uri := server.URL
+	dir := t.TempDir()
+
+	// The same calls are made while recording and replaying, and must give the same results
+	check := func(index *PackageIndex, mode string) {
+		reqs, err := index.FetchPackageRequirements("foo")
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if len(reqs) != 1 || reqs[0].Name != "bar" {
+			t.Errorf("%s: want requirement bar, got %+v", mode, reqs)
+		}
+		if _, err := index.Releases("missing"); err == nil || !strings.Contains(err.Error(), "[no-files]") {
+			t.Errorf("%s: want [no-files] error for a missing package, got %v", mode, err)
+		}
+		mirror := filepath.Join(dir, mode+"-mirror")
+		if err := Mirror(index, []*Requirement{{Name: "foo"}}, mirror, nil); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		modules, err := (&PackageIndex{URI: mirror}).FetchSourceTopLevelModules("foo")
+		if err != nil || !reflect.DeepEqual(modules, []string{"foo"}) {
+			t.Errorf("%s: want top-level module foo in the mirror, got %v (error: %v)", mode, modules, err)
+		}
+	}
+
+	recordings := filepath.Join(dir, "recordings")
+	check(&PackageIndex{URI: uri, Fetcher: &Recorder{Fetcher: &HTTPFetcher{}, Dir: recordings}}, "record")
+	server.Close()
+	replayer := &Replayer{Dir: recordings}
+	check(&PackageIndex{URI: uri, Fetcher: replayer}, "replay")
+
+	var notRecorded *NotRecordedError
+	if _, err := (&PackageIndex{URI: uri, Fetcher: replayer}).Releases("bar"); !errors.As(err, &notRecorded) {
+		t.Errorf("want *NotRecordedError, got %v", err)
+	}
+}
+
+func TestRecordReplayErrors(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0"}}})
+	releases, err := (&PackageIndex{URI: server.URL}).Releases("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := releases[0].Files[0]
+	corrupt := *file
+	corrupt.URL += "?corrupt"
+	corrupt.Hashes = fetch.Hashes{"sha256": strings.Repeat("0", 64)}
+	recorder := &Recorder{Fetcher: &HTTPFetcher{}, Dir: t.TempDir()}
+	ctx := context.Background()
+
+	// A failure other than an HTTP status is replayed with the same message
+	recordErr := recorder.DownloadFile(ctx, &corrupt, filepath.Join(t.TempDir(), "archive"))
+	var mismatch *fetch.HashMismatchError
+	if !errors.As(recordErr, &mismatch) {
+		t.Fatalf("want *fetch.HashMismatchError, got %v", recordErr)
+	}
+	if err := recorder.DownloadFile(ctx, file, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	replayer := &Replayer{Dir: recorder.Dir}
+	var recorded *RecordedError
+	err = replayer.DownloadFile(ctx, &corrupt, filepath.Join(t.TempDir(), "archive"))
+	if !errors.As(err, &recorded) || err.Error() != recordErr.Error() {
+		t.Errorf("want *RecordedError %q, got %v", recordErr, err)
+	}
+
+	// A recorded archive that no longer matches the file's hashes is rejected
+	if err := replayer.DownloadFile(ctx, file, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+	tampered := *file
+	tampered.Hashes = corrupt.Hashes
+	if err := replayer.DownloadFile(ctx, &tampered, filepath.Join(t.TempDir(), "archive")); !errors.As(err, &mismatch) {
+		t.Errorf("want *fetch.HashMismatchError for a recording that doesn't match, got %v", err)
+	}
+}
+
+func TestLocalFetcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := Mirror(&PackageIndex{URI: "testdata/findlinks"}, []*Requirement{{Name: "foo-bar"}}, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mirror stands in for the index it was mirrored from
+	index := &PackageIndex{URI: "https://pypi.example.com", Fetcher: &LocalFetcher{Dir: dir}}
+	pkgs, err := index.AllPackages()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(pkgs, []string{"foo-bar"}) {
+		t.Errorf("want packages [foo-bar], got %v", pkgs)
+	}
+	releases, err := index.Releases("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(releases) != 1 || releases[0].Version != "1.0" {
+		t.Errorf("want release 1.0, got %+v", releases)
+	}
+	modules, err := index.FetchSourceTopLevelModules("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(modules, []string{"foo_bar"}) {
+		t.Errorf("want top-level module foo_bar, got %v", modules)
+	}
+	if _, err := index.Releases("missing"); err == nil || !strings.Contains(err.Error(), "[no-files]") {
+		t.Errorf("want [no-files] error for a missing package, got %v", err)
+	}
+}
+
+func TestFetchContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := index.FetchPackageRequirementsContext(ctx, "foo"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	set := NewIndexSet(index, &PackageIndex{URI: "testdata/findlinks"})
+	if _, err := set.AllPackagesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if err := MirrorContext(ctx, index, []*Requirement{{Name: "foo"}}, t.TempDir(), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestUnverifiedRangeReads(t *testing.T) {
+	// An egg with a large, incompressible file besides its metadata, published with its hash
+	filler := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(filler)
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{
+		Version:     "1.0",
+		RequiresTxt: "bar\n",
+		ExtraFiles:  map[string]string{"foo/data.bin": string(filler)},
+		Formats:     []pypitest.Format{pypitest.Egg},
+	}}})
+	defer server.Close()
+	archivePath := "/packages/" + pypitest.Filename("foo", "1.0", pypitest.Egg)
+
+	// By default, the whole archive is downloaded to check its hash
+	if _, err := (&PackageIndex{URI: server.URL}).FetchPackageRequirements("foo"); err != nil {
+		t.Fatal(err)
+	}
+	size := server.BytesSent(archivePath)
+	if size < int64(len(filler)) {
+		t.Fatalf("want the whole archive downloaded, got %d bytes", size)
+	}
+
+	index := &PackageIndex{URI: server.URL, Client: &fetch.Client{UnverifiedRangeReads: true}}
+	reqs, err := index.FetchPackageRequirements("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reqs) != 1 || reqs[0].Name != "bar" {
+		t.Errorf("want requirement bar, got %+v", reqs)
+	}
+	if sent := server.BytesSent(archivePath) - size; sent >= size/4 {
+		t.Errorf("want only part of the archive downloaded with Range requests, got %d of %d bytes", sent, size)
+	}
+}
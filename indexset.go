@@ -0,0 +1,247 @@
+package cheerio
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// The queries that can be made of a package index. Both a single PackageIndex and an IndexSet implement it. Each query has a variant that takes
+// a context, and gives up once the context is done.
+type Index interface {
+	AllPackages() ([]string, error)
+	Releases(pkg string) ([]*Release, error)
+	FetchRawMetadata(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error)
+	FetchMetadataFiles(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error)
+	FetchMetadataBundle(pkg string) (*MetadataBundle, error)
+	FetchMetadata(pkg string) (*Metadata, error)
+	FetchPackageRequirements(pkg string) ([]*Requirement, error)
+	FetchSourceRepoURL(pkg string) (string, error)
+	FetchSourceRepo(pkg string) (*RepoRef, error)
+	FetchSourceRepoCandidates(pkg string) ([]*RepoCandidate, error)
+	FetchSourceTopLevelModules(pkg string) ([]string, error)
+
+	AllPackagesContext(ctx context.Context) ([]string, error)
+	ReleasesContext(ctx context.Context, pkg string) ([]*Release, error)
+	FetchRawMetadataContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error)
+	FetchMetadataFilesContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error)
+	FetchMetadataBundleContext(ctx context.Context, pkg string) (*MetadataBundle, error)
+	FetchMetadataContext(ctx context.Context, pkg string) (*Metadata, error)
+	FetchPackageRequirementsContext(ctx context.Context, pkg string) ([]*Requirement, error)
+	FetchSourceRepoURLContext(ctx context.Context, pkg string) (string, error)
+	FetchSourceRepoContext(ctx context.Context, pkg string) (*RepoRef, error)
+	FetchSourceRepoCandidatesContext(ctx context.Context, pkg string) ([]*RepoCandidate, error)
+	FetchSourceTopLevelModulesContext(ctx context.Context, pkg string) ([]string, error)
+}
+
+var _ Index = (*PackageIndex)(nil)
+var _ Index = (*IndexSet)(nil)
+
+// How an IndexSet combines the files its indexes list for a package.
+type IndexMode int
+
+const (
+	// Use only the files of the first index (in priority order) that lists the package. A private index can then shadow public packages of the
+	// same name.
+	PriorityMode IndexMode = iota
+
+	// Merge the files of all indexes that list the package, as pip does with --extra-index-url. If several indexes list a file with the same
+	// name, the one with the highest priority is used.
+	MergeMode
+)
+
+// A primary package index plus extra indexes, queried together.
+type IndexSet struct {
+	Indexes []*PackageIndex // in priority order, primary index first
+	Mode    IndexMode
+
+	// The code hosts whose repository URLs are recognized when inferring a package's source repository, tried in order. If nil,
+	// DefaultRepoHosts is used; the RepoHosts of the member indexes don't apply to the set.
+	RepoHosts []*RepoHost
+
+	servedByMu sync.Mutex
+	servedBy   map[string]*PackageIndex
+}
+
+// Returns an IndexSet in PriorityMode, with the primary index taking precedence over the extra indexes.
+func NewIndexSet(primary *PackageIndex, extra ...*PackageIndex) *IndexSet {
+	return &IndexSet{Indexes: append([]*PackageIndex{primary}, extra...)}
+}
+
+func (s *IndexSet) repoHosts() []*RepoHost {
+	return repoHostsOrDefault(s.RepoHosts)
+}
+
+// Returns the index that served the most recent metadata answer for a package (e.g., from FetchRawMetadata or FetchSourceRepoURL), or nil if
+// there has been none.
+func (s *IndexSet) ServedBy(pkg string) *PackageIndex {
+	s.servedByMu.Lock()
+	defer s.servedByMu.Unlock()
+	return s.servedBy[NormalizedPkgName(pkg)]
+}
+
+func (s *IndexSet) recordServedBy(pkg string, idx *PackageIndex) {
+	s.servedByMu.Lock()
+	defer s.servedByMu.Unlock()
+	if s.servedBy == nil {
+		s.servedBy = make(map[string]*PackageIndex)
+	}
+	s.servedBy[NormalizedPkgName(pkg)] = idx
+}
+
+// Get names of all packages served by any of the indexes.
+func (s *IndexSet) AllPackages() ([]string, error) {
+	return s.AllPackagesContext(context.Background())
+}
+
+func (s *IndexSet) AllPackagesContext(ctx context.Context) ([]string, error) {
+	var pkgs []string
+	seen := make(map[string]bool)
+	for _, idx := range s.Indexes {
+		idxPkgs, err := idx.AllPackagesContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range idxPkgs {
+			if !seen[NormalizedPkgName(pkg)] {
+				seen[NormalizedPkgName(pkg)] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// Lists the releases of a package, combining the indexes according to the set's mode. DistFile.Index records where each file came from.
+func (s *IndexSet) Releases(pkg string) ([]*Release, error) {
+	return s.ReleasesContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) ReleasesContext(ctx context.Context, pkg string) ([]*Release, error) {
+	files, err := s.distFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("[no-files] no files found for pkg %s", pkg)
+	}
+	return groupReleases(files), nil
+}
+
+func (s *IndexSet) FetchRawMetadata(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error) {
+	return s.FetchRawMetadataContext(context.Background(), pkg, tarPattern, eggPattern, zipPattern)
+}
+
+func (s *IndexSet) FetchRawMetadataContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error) {
+	files, err := s.FetchMetadataFilesContext(ctx, pkg, tarPattern, eggPattern, zipPattern)
+	if err != nil {
+		return nil, err
+	}
+	return concatFiles(files), nil
+}
+
+func (s *IndexSet) FetchMetadataFiles(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error) {
+	return s.FetchMetadataFilesContext(context.Background(), pkg, tarPattern, eggPattern, zipPattern)
+}
+
+func (s *IndexSet) FetchMetadataFilesContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error) {
+	files, err := s.distFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	metadata, file, err := fetchLatestMetadataFiles(ctx, pkg, installableFiles(files), tarPattern, eggPattern, zipPattern)
+	if err == nil {
+		s.recordServedBy(pkg, file.Index)
+	}
+	return metadata, err
+}
+
+func (s *IndexSet) FetchMetadataBundle(pkg string) (*MetadataBundle, error) {
+	return s.FetchMetadataBundleContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchMetadataBundleContext(ctx context.Context, pkg string) (*MetadataBundle, error) {
+	files, err := s.distFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := fetchLatestMetadataBundle(ctx, pkg, installableFiles(files), s.repoHosts())
+	if err == nil {
+		s.recordServedBy(pkg, bundle.File.Index)
+	}
+	return bundle, err
+}
+
+func (s *IndexSet) FetchMetadata(pkg string) (*Metadata, error) {
+	return s.FetchMetadataContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchMetadataContext(ctx context.Context, pkg string) (*Metadata, error) {
+	return fetchMetadata(ctx, s, pkg)
+}
+
+func (s *IndexSet) FetchPackageRequirements(pkg string) ([]*Requirement, error) {
+	return s.FetchPackageRequirementsContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchPackageRequirementsContext(ctx context.Context, pkg string) ([]*Requirement, error) {
+	return fetchPackageRequirements(ctx, s, pkg)
+}
+
+func (s *IndexSet) FetchSourceRepoURL(pkg string) (string, error) {
+	return s.FetchSourceRepoURLContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchSourceRepoURLContext(ctx context.Context, pkg string) (string, error) {
+	return fetchSourceRepoURL(ctx, s, s.repoHosts(), pkg)
+}
+
+func (s *IndexSet) FetchSourceRepo(pkg string) (*RepoRef, error) {
+	return s.FetchSourceRepoContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchSourceRepoContext(ctx context.Context, pkg string) (*RepoRef, error) {
+	return fetchSourceRepo(ctx, s, s.repoHosts(), pkg)
+}
+
+func (s *IndexSet) FetchSourceRepoCandidates(pkg string) ([]*RepoCandidate, error) {
+	return s.FetchSourceRepoCandidatesContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchSourceRepoCandidatesContext(ctx context.Context, pkg string) ([]*RepoCandidate, error) {
+	candidates, _, err := fetchSourceRepoCandidates(ctx, s, s.repoHosts(), pkg)
+	return candidates, err
+}
+
+func (s *IndexSet) FetchSourceTopLevelModules(pkg string) ([]string, error) {
+	return s.FetchSourceTopLevelModulesContext(context.Background(), pkg)
+}
+
+func (s *IndexSet) FetchSourceTopLevelModulesContext(ctx context.Context, pkg string) ([]string, error) {
+	return fetchSourceTopLevelModules(ctx, s, pkg)
+}
+
+// Returns the files listed for a package, combined according to the set's mode. An error from any index that is consulted is returned rather
+// than skipped, so that an unreachable primary index never silently falls through to the extra indexes.
+func (s *IndexSet) distFiles(ctx context.Context, pkg string) ([]*DistFile, error) {
+	var files []*DistFile
+	seen := make(map[string]bool)
+	for _, idx := range s.Indexes {
+		idxFiles, err := idx.distFiles(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range idxFiles {
+			if !seen[file.Filename] {
+				seen[file.Filename] = true
+				files = append(files, file)
+			}
+		}
+		if s.Mode == PriorityMode && len(files) > 0 {
+			break
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,89 @@
+package cheerio
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
+)
+
+// Starts a server whose simple index lists the given files for every package.
+func simpleIndexServer(filenames ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, filename := range filenames {
+			fmt.Fprintf(w, "<a href=\"/packages/%s\">%s</a><br/>\n", filename, filename)
+		}
+	}))
+}
+
+func TestIndexSet(t *testing.T) {
+	primary := simpleIndexServer("foo-1.0.tar.gz")
+	defer primary.Close()
+	extra := simpleIndexServer("foo-1.0.tar.gz", "foo-2.0.tar.gz")
+	defer extra.Close()
+
+	primaryIndex, extraIndex := &PackageIndex{URI: primary.URL}, &PackageIndex{URI: extra.URL}
+	indexes := NewIndexSet(primaryIndex, extraIndex)
+
+	releases, err := indexes.Releases("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || releases[0].Files[0].Index != primaryIndex {
+		t.Errorf("PriorityMode: want only the primary index's release, got %d releases", len(releases))
+	}
+
+	indexes.Mode = MergeMode
+	releases, err = indexes.Releases("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("MergeMode: want 2 releases, got %d", len(releases))
+	}
+	if releases[0].Files[0].Index != primaryIndex {
+		t.Errorf("MergeMode: want foo-1.0.tar.gz from the primary index, got %s", releases[0].Files[0].Index.URI)
+	}
+	if releases[1].Files[0].Index != extraIndex {
+		t.Errorf("MergeMode: want foo-2.0.tar.gz from the extra index, got %s", releases[1].Files[0].Index.URI)
+	}
+}
+
+func TestServedBy(t *testing.T) {
+	primary := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0"}}})
+	defer primary.Close()
+	extra := pypitest.NewServer(
+		&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0"}, {Version: "2.0"}}},
+		&pypitest.Project{Name: "bar", Releases: []*pypitest.Release{{Version: "1.0"}}},
+	)
+	defer extra.Close()
+
+	primaryIndex, extraIndex := &PackageIndex{URI: primary.URL}, &PackageIndex{URI: extra.URL}
+	indexes := NewIndexSet(primaryIndex, extraIndex)
+	if idx := indexes.ServedBy("foo"); idx != nil {
+		t.Errorf("want nil before any query, got %s", idx.URI)
+	}
+
+	tests := []struct {
+		mode      IndexMode
+		pkg       string
+		wantIndex *PackageIndex
+	}{
+		{PriorityMode, "foo", primaryIndex}, // the primary index shadows the extra index's newer release
+		{PriorityMode, "bar", extraIndex},
+		{MergeMode, "foo", extraIndex}, // the newest release is only on the extra index
+		{MergeMode, "Bar", extraIndex},
+	}
+	for _, test := range tests {
+		indexes.Mode = test.mode
+		if _, err := indexes.FetchMetadata(test.pkg); err != nil {
+			t.Errorf("%s (mode %d): %v", test.pkg, test.mode, err)
+			continue
+		}
+		if idx := indexes.ServedBy(test.pkg); idx != test.wantIndex {
+			t.Errorf("%s (mode %d): want %s, got %v", test.pkg, test.mode, test.wantIndex.URI, idx)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cheerio
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An index whose URI is a file:// URL or a filesystem path is read from local disk. If the directory contains a PEP 503 "simple/" tree (e.g.,
+// one written by Mirror), it is read like a remote index. Otherwise, it is treated as a flat directory of distribution files, like pip's
+// --find-links.
+
+// Returns the directory of a local index, and whether the index is local.
+func (p *PackageIndex) localDir() (string, bool) {
+	u, err := url.Parse(p.URI)
+	if err != nil {
+		return "", false
+	}
+	switch u.Scheme {
+	case "file":
+		return filepath.FromSlash(u.Path), true
+	case "":
+		return p.URI, true
+	}
+	return "", false
+}
+
+// Returns the directory of a local index without a simple/ tree, and whether the index is such a flat directory.
+func (p *PackageIndex) flatDir() (string, bool) {
+	dir, isLocal := p.localDir()
+	if !isLocal {
+		return "", false
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "simple")); err == nil && fi.IsDir() {
+		return "", false
+	}
+	return dir, true
+}
+
+// Returns the file:// URL of a local path.
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// Lists the files of a package in a flat directory.
+func (p *PackageIndex) flatDirFiles(dir, pkg string) ([]*DistFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*DistFile
+	for _, entry := range entries {
+		if entry.IsDir() || canonicalName(projectFromFilename(entry.Name(), pkg)) != canonicalName(pkg) {
+			continue
+		}
+		files = append(files, &DistFile{
+			Filename: entry.Name(),
+			URL:      fileURL(filepath.Join(dir, entry.Name())),
+			Version:  versionFromFilename(pkg, entry.Name()),
+			Index:    p,
+		})
+	}
+	return files, nil
+}
+
+// Lists the names of the packages that have files in a flat directory.
+func flatDirPackages(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if pkg := projectFromFilename(entry.Name(), ""); pkg != "" && !seen[canonicalName(pkg)] {
+			seen[canonicalName(pkg)] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+var sdistVersionStartRegexp = regexp.MustCompile(`-[0-9]`)
+
+// Returns the project name in a distribution filename, or "" if it isn't a distribution. Sdist names are ambiguous when the project name contains
+// dashes, so if the filename starts with the given package name, that is returned; otherwise the name is assumed to end before the first
+// dash that is followed by a digit.
+func projectFromFilename(filename, pkg string) string {
+	base := distExtRegexp.ReplaceAllString(filename, "")
+	if base == filename {
+		return ""
+	}
+	if strings.HasSuffix(filename, ".whl") || strings.HasSuffix(filename, ".egg") {
+		return strings.Split(base, "-")[0]
+	}
+	if pkg != "" && len(base) > len(pkg) && base[len(pkg)] == '-' && canonicalName(base[:len(pkg)]) == canonicalName(pkg) {
+		return base[:len(pkg)]
+	}
+	if loc := sdistVersionStartRegexp.FindStringIndex(base); loc != nil {
+		return base[:loc[0]]
+	}
+	return ""
+}
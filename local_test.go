@@ -0,0 +1,105 @@
+package cheerio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFlatDirIndex(t *testing.T) {
+	for _, uri := range []string{"testdata/findlinks", fileURL("testdata/findlinks")} {
+		index := &PackageIndex{URI: uri}
+
+		pkgs, err := index.AllPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"foo-bar", "other"}; !reflect.DeepEqual(pkgs, want) {
+			t.Errorf("%s: want packages %v, got %v", uri, want, pkgs)
+		}
+
+		releases, err := index.Releases("foo_bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(releases) != 2 || releases[0].Version != "0.9" || releases[1].Version != "1.0" {
+			t.Errorf("%s: want releases 0.9 and 1.0, got %+v", uri, releases)
+		}
+
+		reqs, err := index.FetchPackageRequirements("foo-bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*Requirement{{Name: "requests", Constraint: ">=", Version: "1.0"}, {Name: "six"}}
+		if !reflect.DeepEqual(reqs, want) {
+			t.Errorf("%s: want requirements %+v, got %+v", uri, want, reqs)
+		}
+
+		repo, err := index.FetchSourceRepoURL("foo-bar")
+		if err != nil {
+			t.Fatal(err)
+		} else if repo != "https://github.com/example/foo-bar" {
+			t.Errorf("%s: want repo https://github.com/example/foo-bar, got %q", uri, repo)
+		}
+
+		modules, err := index.FetchSourceTopLevelModules("foo-bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(modules, []string{"foo_bar"}) {
+			t.Errorf("%s: want top-level module foo_bar, got %v", uri, modules)
+		}
+	}
+}
+
+func TestRemoteIndexFileLinks(t *testing.T) {
+	secret := fileURL("testdata/findlinks/other-2.0.tar.gz")
+	htmlPage := fmt.Sprintf("<a href=\"%s#sha256=0\">foo-1.0.tar.gz</a>\n<a href=\"/packages/foo-2.0.tar.gz\">foo-2.0.tar.gz</a>\n", secret)
+	jsonPage := fmt.Sprintf(`{"files": [{"filename": "foo-1.0.tar.gz", "url": %q}, {"filename": "foo-2.0.tar.gz", "url": "/packages/foo-2.0.tar.gz"}]}`,
+		secret)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, htmlPage)
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL + "/simple/foo/")
+	htmlFiles, err := parseSimpleHTML(base, []byte(htmlPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFiles, err := parseSimpleJSON(base, []byte(jsonPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, files := range [][]*DistFile{htmlFiles, jsonFiles} {
+		if len(files) != 1 || files[0].Filename != "foo-2.0.tar.gz" {
+			t.Errorf("want only the file on the server, got %d files", len(files))
+		}
+	}
+
+	index := &PackageIndex{URI: server.URL}
+	releases, err := index.Releases("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || releases[0].Version != "2.0" {
+		t.Errorf("want only the release on the server, got %d releases", len(releases))
+	}
+
+	// Even if such a link got through, the index's client won't read it
+	file := &DistFile{Filename: "foo-1.0.tar.gz", URL: secret, Index: index}
+	if err := file.fetcher().DownloadFile(context.Background(), file, filepath.Join(t.TempDir(), file.Filename)); err == nil {
+		t.Errorf("want error downloading a local file through a remote index")
+	}
+
+	// A local index may link to local files
+	local, _ := url.Parse(fileURL("testdata/simple/foo/"))
+	if files, err := parseSimpleHTML(local, []byte(htmlPage)); err != nil || len(files) != 2 {
+		t.Errorf("want both files linked from a local page, got %d (error: %v)", len(files), err)
+	}
+}
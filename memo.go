@@ -0,0 +1,175 @@
+package cheerio
+
+import (
+	"container/list"
+	"context"
+	"regexp"
+	"sync"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// A PackageIndex shares work between concurrent callers: identical requests for a package's files or an archive's metadata that are made while
+// one is already in flight wait for its result rather than repeating it. Recent metadata results are also kept in memory. A caller that stops
+// waiting because its context is done doesn't affect the others: the shared work is only cancelled once none of its callers is waiting for it.
+// Callers are given copies of shared results, so they are free to modify them.
+
+const DefaultMemoryCacheSize = 1024
+
+// Returns the in-memory cache of metadata results, or nil if it is disabled.
+func (p *PackageIndex) memoryCache() *lruCache {
+	p.resultsOnce.Do(func() {
+		size := p.MemoryCacheSize
+		if size == 0 {
+			size = DefaultMemoryCacheSize
+		}
+		if size > 0 {
+			p.results = newLRUCache(size)
+		}
+	})
+	return p.results
+}
+
+// Returns the metadata extracted from an archive, from the in-memory cache, from an identical extraction already in flight, or by calling
+// extract. The result is only kept in the cache if keep is set (see DistFile.cacheable). Each caller gets its own copy, which it may modify
+// without affecting the cached result.
+func (p *PackageIndex) memoizeMetadata(ctx context.Context, key string, keep bool, extract func(ctx context.Context) (fetch.Files, error)) (fetch.Files, error) {
+	results := p.memoryCache()
+	if !keep {
+		results = nil
+	}
+	if results != nil {
+		if files, in := results.get(key); in {
+			return copyFiles(files.(fetch.Files)), nil
+		}
+	}
+	files, err := p.flights.do(ctx, "metadata "+key, func(ctx context.Context) (interface{}, error) {
+		files, err := extract(ctx)
+		if err == nil && results != nil {
+			results.add(key, files)
+		}
+		return files, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyFiles(files.(fetch.Files)), nil
+}
+
+// Copies extracted files, including their contents.
+func copyFiles(files fetch.Files) fetch.Files {
+	copied := make(fetch.Files, len(files))
+	for name, data := range files {
+		copied[name] = append([]byte(nil), data...)
+	}
+	return copied
+}
+
+// Returns the key identifying the files extracted from an archive with the given pattern.
+func (f *DistFile) metadataKey(pattern *regexp.Regexp) string {
+	algorithm, digest := f.cacheKey()
+	return algorithm + ":" + digest + " " + pattern.String()
+}
+
+// Collapses concurrent calls with the same key into one.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	waiters int                // number of callers waiting for the result
+	cancel  context.CancelFunc // cancels the call, once no caller is waiting for it
+	val     interface{}
+	err     error
+}
+
+// Calls fn and returns its result, unless a call with the same key is in flight, in which case it waits for that call and returns its result.
+// The call is made with a context of its own, which is cancelled once every caller waiting for it has stopped, so a caller whose context is done
+// only stops waiting, and returns its context's error.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f, in := g.calls[key]
+	if !in {
+		callCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = f
+		go func() {
+			f.val, f.err = fn(callCtx)
+			g.mu.Lock()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// Later callers start a new call rather than joining one that is being cancelled
+			f.cancel()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// A fixed-size cache that evicts the least recently used entry.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	val interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, in := c.entries[key]
+	if !in {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).val, true
+}
+
+func (c *lruCache) add(key string, val interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, in := c.entries[key]; in {
+		elem.Value.(*lruEntry).val = val
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, val: val})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
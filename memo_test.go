@@ -0,0 +1,199 @@
+package cheerio
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	var calls int32
+	const n = 10
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do(context.Background(), "foo", func(context.Context) (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "bar", nil
+			})
+		}(i)
+	}
+
+	// Wait until every caller is waiting before letting the call finish
+	waitForWaiters(&g, "foo", n)
+	close(release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("want 1 call, got %d", calls)
+	}
+	for i, result := range results {
+		if result != "bar" {
+			t.Errorf("caller %d: want bar, got %v", i, result)
+		}
+	}
+}
+
+func TestFlightGroupContext(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+			return "bar", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, err := g.do(firstCtx, "foo", fn)
+		firstErr <- err
+	}()
+	waitForWaiters(&g, "foo", 1)
+
+	// A caller whose own context is done stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.do(ctx, "foo", fn); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+
+	// The call goes on for the callers still waiting when the one that started it stops
+	result := make(chan interface{})
+	go func() {
+		val, _ := g.do(context.Background(), "foo", fn)
+		result <- val
+	}()
+	waitForWaiters(&g, "foo", 2)
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled for the cancelled caller, got %v", err)
+	}
+	close(release)
+	if val := <-result; val != "bar" {
+		t.Errorf("want bar, got %v", val)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("want 1 call, got %d", calls)
+	}
+
+	// The call is cancelled once no caller is waiting for it
+	cancelled := make(chan struct{})
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		g.do(ctx, "baz", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		})
+	}()
+	waitForWaiters(&g, "baz", 1)
+	cancel()
+	<-cancelled
+}
+
+// Waits until n callers are waiting for the call with the given key.
+func waitForWaiters(g *flightGroup, key string, n int) {
+	for {
+		g.mu.Lock()
+		f := g.calls[key]
+		joined := f != nil && f.waiters == n
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	c.add("c", 3)
+
+	if _, in := c.get("b"); in {
+		t.Errorf("want least recently used entry b evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, in := c.get(key); !in {
+			t.Errorf("want %s kept", key)
+		}
+	}
+}
+
+func TestMemoizedMetadata(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{
+		{Version: "1.0", RequiresTxt: "bar\n"},
+	}})
+	defer server.Close()
+	archivePath := "/packages/" + pypitest.Filename("foo", "1.0", pypitest.Sdist)
+
+	index := &PackageIndex{URI: server.URL}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reqs, err := index.FetchPackageRequirements("foo"); err != nil || len(reqs) != 1 {
+				t.Errorf("want 1 requirement, got %d (error: %v)", len(reqs), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := server.Requests(archivePath); got != 1 {
+		t.Errorf("want the archive downloaded once, got %d downloads", got)
+	}
+
+	// With the in-memory cache disabled, only concurrent calls are shared
+	index = &PackageIndex{URI: server.URL, MemoryCacheSize: -1}
+	for i := 0; i < 2; i++ {
+		if _, err := index.FetchPackageRequirements("foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := server.Requests(archivePath); got != 3 {
+		t.Errorf("want 2 more downloads with the in-memory cache disabled, got %d", got-1)
+	}
+}
+
+func TestMemoizedMetadataCopies(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0", RequiresTxt: "bar\n"}}})
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	files, err := index.FetchMetadataFiles("foo", requiresTxtTarPattern, requiresTxtEggPattern, requiresTxtZipPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		copy(data, "xxx")
+		delete(files, name)
+	}
+
+	bundle, err := index.FetchMetadataBundle("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.RequiresTxt[0] = 'x'
+	if reqs, err := index.FetchPackageRequirements("foo"); err != nil || len(reqs) != 1 || reqs[0].Name != "bar" {
+		t.Errorf("a caller modifying its result changed later results: got %+v (error: %v)", reqs, err)
+	}
+}
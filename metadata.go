@@ -0,0 +1,257 @@
+package cheerio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// The core metadata of a distribution, as found in the PKG-INFO of an sdist or egg and the METADATA of a wheel
+// (https://packaging.python.org/specifications/core-metadata/). Metadata versions 1.0 through 2.4 are understood; fields that a version doesn't
+// define are simply empty.
+type Metadata struct {
+	MetadataVersion string
+	Name            string
+	Version         string
+	Summary         string
+	HomePage        string
+	DownloadURL     string
+	ProjectURLs     []*ProjectURL
+
+	Author          string
+	AuthorEmail     string
+	Maintainer      string
+	MaintainerEmail string
+
+	License           string   // free text, possibly several lines
+	LicenseExpression string   // an SPDX expression (2.4)
+	LicenseFiles      []string // paths of license files in the distribution (2.4)
+
+	Keywords       string
+	Classifiers    []string
+	RequiresDist   []string
+	RequiresPython string
+	ProvidesExtra  []string
+
+	Description            string
+	DescriptionContentType string
+
+	// Every field, keyed by its canonical name (e.g., "Home-Page", "Requires-Dist"), including those not broken out above. The description is
+	// here too, whether it was given as a field or as the message body.
+	Fields map[string][]string
+}
+
+// A labeled URL of a project, from a Project-URL field (e.g., "Source, https://github.com/foo/bar").
+type ProjectURL struct {
+	Label string
+	URL   string
+}
+
+// Parses core metadata. The format is that of an email message: "Name: value" fields, each continued on the lines that follow it that start
+// with whitespace, optionally followed by a blank line and the description as the message body. Field names are case-insensitive, and fields
+// that can occur more than once (Classifier, Requires-Dist, ...) collect all of their values. Malformed headers are read as Python's email parser
+// reads them, as pip does: a line that isn't a field starts the body, and a continuation line with no field before it is ignored. It is an
+// error only if the metadata version is unsupported or there is no Name.
+func ParseMetadata(b []byte) (*Metadata, error) {
+	fields := make(map[string][]string)
+	var body bytes.Buffer
+	var name string // of the field being read
+	inBody := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, len(b)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case inBody:
+			body.WriteString(line)
+			body.WriteByte('\n')
+		case line == "":
+			inBody = true
+		case line[0] == ' ' || line[0] == '\t':
+			if name == "" {
+				continue // nothing to continue
+			}
+			values := fields[name]
+			values[len(values)-1] += "\n" + unindentContinuation(line)
+		default:
+			colon := strings.Index(line, ":")
+			if colon <= 0 {
+				// Tools that forgot the blank line before the description
+				inBody = true
+				body.WriteString(line)
+				body.WriteByte('\n')
+				continue
+			}
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:colon]))
+			fields[name] = append(fields[name], strings.TrimSpace(line[colon+1:]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{Fields: fields}
+	m.MetadataVersion = m.field("Metadata-Version")
+	if err := checkMetadataVersion(m.MetadataVersion); err != nil {
+		return nil, err
+	}
+	if description := strings.TrimRight(body.String(), "\n"); description != "" {
+		fields["Description"] = append(fields["Description"], description)
+	}
+
+	m.Name = m.field("Name")
+	m.Version = m.field("Version")
+	m.Summary = m.field("Summary")
+	m.HomePage = m.field("Home-Page")
+	m.DownloadURL = m.field("Download-Url")
+	for _, value := range fields["Project-Url"] {
+		m.ProjectURLs = append(m.ProjectURLs, parseProjectURL(unfold(value)))
+	}
+	m.Author = m.field("Author")
+	m.AuthorEmail = m.field("Author-Email")
+	m.Maintainer = m.field("Maintainer")
+	m.MaintainerEmail = m.field("Maintainer-Email")
+	if license := strings.TrimSpace(first(fields["License"])); license != "UNKNOWN" {
+		m.License = license
+	}
+	m.LicenseExpression = m.field("License-Expression")
+	m.LicenseFiles = m.multiField("License-File")
+	m.Keywords = m.field("Keywords")
+	m.Classifiers = m.multiField("Classifier")
+	m.RequiresDist = m.multiField("Requires-Dist")
+	m.RequiresPython = m.field("Requires-Python")
+	m.ProvidesExtra = m.multiField("Provides-Extra")
+	m.DescriptionContentType = m.field("Description-Content-Type")
+	// The body takes precedence over a Description field, as it is the newer form
+	if descriptions := fields["Description"]; len(descriptions) > 0 {
+		m.Description = descriptions[len(descriptions)-1]
+	}
+
+	if m.Name == "" {
+		return nil, fmt.Errorf("Bad metadata: no Name field")
+	}
+	return m, nil
+}
+
+// Returns the first value of a single-valued field, with its lines joined. Old versions of setuptools wrote "UNKNOWN" for fields they had no
+// value for, which is read as no value.
+func (m *Metadata) field(name string) string {
+	if value := unfold(first(m.Fields[name])); value != "UNKNOWN" {
+		return value
+	}
+	return ""
+}
+
+// Returns the values of a multi-valued field, with their lines joined.
+func (m *Metadata) multiField(name string) []string {
+	var values []string
+	for _, value := range m.Fields[name] {
+		values = append(values, unfold(value))
+	}
+	return values
+}
+
+// Returns the URL of the project link with the given label, compared case-insensitively and ignoring punctuation and whitespace as PEP 753
+// describes (so "Source Code" matches "source-code"), or "" if there is none.
+func (m *Metadata) ProjectURL(label string) string {
+	for _, u := range m.ProjectURLs {
+		if normalizeURLLabel(u.Label) == normalizeURLLabel(label) {
+			return u.URL
+		}
+	}
+	return ""
+}
+
+func normalizeURLLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t-_.:", r) {
+			return -1
+		}
+		return r
+	}, strings.ToLower(label))
+}
+
+// Checks that the metadata version is one this parser understands. Metadata without a version is read as 1.0, as it predates the field.
+func checkMetadataVersion(v string) error {
+	if v == "" {
+		return nil
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("Bad metadata version %q", v)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("Bad metadata version %q", v)
+	}
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		return fmt.Errorf("Bad metadata version %q", v)
+	}
+	// Tools must refuse versions with a major version they don't know, but may read newer minor versions
+	if major < 1 || major > 2 {
+		return fmt.Errorf("Unsupported metadata version %s", v)
+	}
+	return nil
+}
+
+// Removes the indentation of a continuation line. Tools indent the lines of a description by eight spaces, or by seven and a "|" so that
+// leading whitespace and blank lines survive. Other continuation lines just lose their indentation.
+func unindentContinuation(line string) string {
+	if strings.HasPrefix(line, "        ") || strings.HasPrefix(line, "       |") {
+		return line[8:]
+	}
+	return strings.TrimLeft(line, " \t")
+}
+
+// Joins the lines of a field value that isn't free text, as unfolding an email header does.
+func unfold(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Parses the value of a Project-URL field: a label, a comma and the URL. Older metadata sometimes has just the URL.
+func parseProjectURL(value string) *ProjectURL {
+	comma := strings.Index(value, ",")
+	if comma < 0 {
+		return &ProjectURL{URL: strings.TrimSpace(value)}
+	}
+	return &ProjectURL{Label: strings.TrimSpace(value[:comma]), URL: strings.TrimSpace(value[comma+1:])}
+}
+
+// Fetches and parses the core metadata (PKG-INFO) of the latest release of a package.
+func (p *PackageIndex) FetchMetadata(pkg string) (*Metadata, error) {
+	return p.FetchMetadataContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchMetadataContext(ctx context.Context, pkg string) (*Metadata, error) {
+	return fetchMetadata(ctx, p, pkg)
+}
+
+func fetchMetadata(ctx context.Context, idx Index, pkg string) (*Metadata, error) {
+	files, err := idx.FetchMetadataFilesContext(ctx, pkg, pkgInfoPattern, pkgInfoPattern, pkgInfoPattern)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMetadata(topLevelFile(pkg, files))
+}
+
+// Parses the PKG-INFO of the bundle, as FetchMetadata does. Returns a *fetch.NoMatchError if there is no PKG-INFO.
+func (b *MetadataBundle) Metadata() (*Metadata, error) {
+	if b.PKGInfo == nil {
+		return nil, &fetch.NoMatchError{Pattern: pkgInfoPattern}
+	}
+	return ParseMetadata(b.PKGInfo)
+}
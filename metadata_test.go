@@ -0,0 +1,144 @@
+package cheerio
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestParseMetadata(t *testing.T) {
+	// Metadata 1.1, as written by old versions of setuptools: the description and license are continued on indented lines
+	v11 := "Metadata-Version: 1.1\n" +
+		"Name: foo\n" +
+		"Version: 1.0\n" +
+		"Summary: Does foo\n" +
+		"Home-page: UNKNOWN\n" +
+		"Author: Jane Doe\n" +
+		"Author-email: jane@example.com\n" +
+		"License: Line one\n" +
+		"        Line two\n" +
+		"Download-URL: https://example.com/foo-1.0.tar.gz\n" +
+		"Description: Foo\n" +
+		"        ===\n" +
+		"        \n" +
+		"        Does foo.\n" +
+		"Platform: UNKNOWN\n" +
+		"Classifier: Programming Language :: Python\n" +
+		"Classifier: License :: OSI Approved :: MIT License\n"
+	m, err := ParseMetadata([]byte(v11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		MetadataVersion: "1.1",
+		Name:            "foo",
+		Version:         "1.0",
+		Summary:         "Does foo",
+		DownloadURL:     "https://example.com/foo-1.0.tar.gz",
+		Author:          "Jane Doe",
+		AuthorEmail:     "jane@example.com",
+		License:         "Line one\nLine two",
+		Classifiers:     []string{"Programming Language :: Python", "License :: OSI Approved :: MIT License"},
+		Description:     "Foo\n===\n\nDoes foo.",
+		Fields:          m.Fields,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("want %+v, got %+v", want, m)
+	}
+	if got := m.Fields["Platform"]; !reflect.DeepEqual(got, []string{"UNKNOWN"}) {
+		t.Errorf("want Platform in Fields, got %q", got)
+	}
+
+	// Metadata 2.4, with the description in the body, field names in other cases and CRLF line endings
+	v24 := "Metadata-Version: 2.4\r\n" +
+		"Name: foo-bar\r\n" +
+		"Version: 2.0\r\n" +
+		"project-url: Source Code, https://github.com/foo/bar\r\n" +
+		"Project-URL: Documentation, https://foo-bar.readthedocs.io\r\n" +
+		"Maintainer: John Doe\r\n" +
+		"Maintainer-email: john@example.com\r\n" +
+		"License-Expression: MIT OR Apache-2.0\r\n" +
+		"License-File: LICENSE\r\n" +
+		"Keywords: foo,bar\r\n" +
+		"Requires-Python: >=3.8\r\n" +
+		"Requires-Dist: requests>=2.0\r\n" +
+		"Requires-Dist: pytest; extra == \"test\"\r\n" +
+		"Provides-Extra: test\r\n" +
+		"Description-Content-Type: text/markdown\r\n" +
+		"\r\n" +
+		"# Foo bar\r\n" +
+		"\r\n" +
+		"Does: foo and bar.\r\n"
+	m, err = ParseMetadata([]byte(v24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &Metadata{
+		MetadataVersion: "2.4",
+		Name:            "foo-bar",
+		Version:         "2.0",
+		ProjectURLs: []*ProjectURL{
+			{Label: "Source Code", URL: "https://github.com/foo/bar"},
+			{Label: "Documentation", URL: "https://foo-bar.readthedocs.io"},
+		},
+		Maintainer:             "John Doe",
+		MaintainerEmail:        "john@example.com",
+		LicenseExpression:      "MIT OR Apache-2.0",
+		LicenseFiles:           []string{"LICENSE"},
+		Keywords:               "foo,bar",
+		RequiresDist:           []string{"requests>=2.0", `pytest; extra == "test"`},
+		RequiresPython:         ">=3.8",
+		ProvidesExtra:          []string{"test"},
+		Description:            "# Foo bar\n\nDoes: foo and bar.",
+		DescriptionContentType: "text/markdown",
+		Fields:                 m.Fields,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("want %+v, got %+v", want, m)
+	}
+	if got := m.ProjectURL("source-code"); got != "https://github.com/foo/bar" {
+		t.Errorf("want the source-code URL, got %q", got)
+	}
+
+	for _, bad := range []string{
+		"Metadata-Version: 3.0\nName: foo\n",
+		"Metadata-Version: 2\nName: foo\n",
+		"Metadata-Version: 2.1\nVersion: 1.0\n",
+		"Name foo\nName: foo\n",
+	} {
+		if _, err := ParseMetadata([]byte(bad)); err == nil {
+			t.Errorf("%q: want error, got nil", bad)
+		}
+	}
+}
+
+func TestParseMalformedMetadata(t *testing.T) {
+	// No blank line before the description, and a continuation line before any field
+	m, err := ParseMetadata([]byte("  stray\nMetadata-Version: 1.1\nName: foo\nHome-page: https://github.com/foo/foo\nDoes foo.\nLicense: MIT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "foo" || m.HomePage != "https://github.com/foo/foo" {
+		t.Errorf("want the fields before the malformed line, got %+v", m)
+	}
+	if m.Description != "Does foo.\nLicense: MIT" || m.License != "" {
+		t.Errorf("want the malformed line to start the body, got description %q and license %q", m.Description, m.License)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{
+		{Version: "1.0"},
+		{Version: "2.0", PKGInfo: "Metadata-Version: 2.1\nName: foo\nVersion: 2.0\nRequires-Dist: bar\n\nDoes foo.\n"},
+	}})
+	defer server.Close()
+
+	m, err := (&PackageIndex{URI: server.URL}).FetchMetadata("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "2.0" || !reflect.DeepEqual(m.RequiresDist, []string{"bar"}) || m.Description != "Does foo." {
+		t.Errorf("want the metadata of foo 2.0, got %+v", m)
+	}
+}
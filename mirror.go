@@ -0,0 +1,249 @@
+package cheerio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// Options for Mirror.
+type MirrorOptions struct {
+	// Mirror every release of each package, rather than only the latest release that satisfies the requirement.
+	AllVersions bool
+
+	// If non-nil, also mirror the packages that each package transitively requires, according to the graph.
+	Graph *PyPIGraph
+
+	// If non-nil, called before each file is downloaded. Files that are already in the mirror and match the hashes the index publishes are
+	// skipped; those the index publishes no hashes for are downloaded again, as they can't be checked.
+	OnDownload func(file *DistFile)
+}
+
+// Downloads the distributions that satisfy the given requirements from an index into dir/packages/, and writes a PEP 503 tree for them to
+// dir/simple/. Files already in the mirror are kept, so a mirror can be built up over several runs. The result can be served as a static site or
+// read directly as a local PackageIndex.
+func Mirror(idx Index, reqs []*Requirement, dir string, opts *MirrorOptions) error {
+	return MirrorContext(context.Background(), idx, reqs, dir, opts)
+}
+
+// Like Mirror, but gives up when ctx is done. Files mirrored by then are kept.
+func MirrorContext(ctx context.Context, idx Index, reqs []*Requirement, dir string, opts *MirrorOptions) error {
+	if opts == nil {
+		opts = &MirrorOptions{}
+	}
+	for _, subdir := range []string{"packages", "simple"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			return err
+		}
+	}
+
+	// Requirements for the same package are mirrored together, so that each of their constraints is satisfied (e.g., both foo<1 and foo>=2)
+	var names []string
+	byName := make(map[string][]*Requirement)
+	for len(reqs) > 0 {
+		req := reqs[0]
+		reqs = reqs[1:]
+		name := canonicalName(req.Name)
+		if hasRequirement(byName[name], req) {
+			continue
+		}
+		if _, in := byName[name]; !in {
+			names = append(names, name)
+			if opts.Graph != nil {
+				for _, dep := range opts.Graph.Requires(req.Name) {
+					reqs = append(reqs, &Requirement{Name: dep})
+				}
+			}
+		}
+		byName[name] = append(byName[name], req)
+	}
+
+	for _, name := range names {
+		if err := mirrorPackage(ctx, idx, byName[name], dir, opts); err != nil {
+			return err
+		}
+	}
+	return writeMirrorRoot(dir)
+}
+
+// Reports whether reqs has a requirement with the same constraint as req.
+func hasRequirement(reqs []*Requirement, req *Requirement) bool {
+	for _, r := range reqs {
+		if r.Constraint == req.Constraint && r.Version == req.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// Mirrors the files of the releases that the requirements for a package select, and rewrites its project page.
+func mirrorPackage(ctx context.Context, idx Index, reqs []*Requirement, dir string, opts *MirrorOptions) error {
+	pkg := reqs[0].Name
+	releases, err := idx.ReleasesContext(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	var selected []*Release
+	chosen := make(map[*Release]bool)
+	for _, req := range reqs {
+		reqReleases := selectReleases(releases, req, opts.AllVersions)
+		if len(reqReleases) == 0 {
+			return fmt.Errorf("No release of %s satisfies %s%s", req.Name, req.Constraint, req.Version)
+		}
+		for _, release := range reqReleases {
+			if !chosen[release] {
+				chosen[release] = true
+				selected = append(selected, release)
+			}
+		}
+	}
+
+	var files []*DistFile
+	for _, release := range selected {
+		for _, file := range release.Files {
+			if !plainFilename(file.Filename) {
+				return fmt.Errorf("Refusing to mirror %s: %q is not a plain file name", file.URL, file.Filename)
+			}
+			path := filepath.Join(dir, "packages", file.Filename)
+			if !file.Hashes.Verifiable() || fetch.VerifyFile(path, file.Hashes) != nil {
+				if opts.OnDownload != nil {
+					opts.OnDownload(file)
+				}
+				if err := file.fetcher().DownloadFile(ctx, file, path); err != nil {
+					return err
+				}
+			}
+			// The file has been checked against every hash the index publishes that can be checked, if any
+			digest, err := sha256File(path)
+			if err != nil {
+				return err
+			}
+
+			mirrored := *file
+			mirrored.Hashes = fetch.Hashes{"sha256": digest}
+			files = append(files, &mirrored)
+		}
+	}
+	return writeMirrorProjectPage(dir, pkg, files)
+}
+
+// Reports whether a filename from an index is a single path element that names a file, so it can't be written outside the mirror.
+func plainFilename(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`+"\x00")
+}
+
+// Returns the releases to mirror for a requirement: all of them if allVersions is set, otherwise the latest that satisfies the requirement.
+// Yanked releases are only chosen if they are pinned with "==".
+func selectReleases(releases []*Release, req *Requirement, allVersions bool) []*Release {
+	if allVersions {
+		return releases
+	}
+	for r := len(releases) - 1; r >= 0; r-- {
+		release := releases[r]
+		if release.Yanked() && req.Constraint != "==" {
+			continue
+		}
+		if req.satisfiedBy(release.Version) {
+			return []*Release{release}
+		}
+	}
+	return nil
+}
+
+// Writes the project page for the given files, keeping the files listed by the existing page.
+func writeMirrorProjectPage(dir, pkg string, files []*DistFile) error {
+	projectDir := filepath.Join(dir, "simple", canonicalName(pkg))
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		return err
+	}
+	pagePath := filepath.Join(projectDir, "index.html")
+
+	byName := make(map[string]*DistFile)
+	if existing, err := ioutil.ReadFile(pagePath); err == nil {
+		base, _ := url.Parse(fileURL(projectDir) + "/")
+		existingFiles, err := parseSimpleHTML(base, existing)
+		if err != nil {
+			return err
+		}
+		for _, file := range existingFiles {
+			byName[file.Filename] = file
+		}
+	}
+	for _, file := range files {
+		byName[file.Filename] = file
+	}
+	merged := make([]*DistFile, 0, len(byName))
+	for _, file := range byName {
+		file.Version = versionFromFilename(pkg, file.Filename)
+		merged = append(merged, file)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Filename < merged[j].Filename })
+	sortDistFiles(merged)
+
+	return writeFileAtomic(pagePath, func(w io.Writer) error {
+		return writeSimpleHTML(w, pkg, merged, func(file *DistFile) string {
+			return "../../packages/" + url.PathEscape(file.Filename)
+		})
+	})
+}
+
+// Writes the root page, listing every project in the mirror.
+func writeMirrorRoot(dir string) error {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "simple"))
+	if err != nil {
+		return err
+	}
+	var projects []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			projects = append(projects, entry.Name())
+		}
+	}
+	return writeFileAtomic(filepath.Join(dir, "simple", "index.html"), func(w io.Writer) error {
+		return writeSimpleRootHTML(w, projects)
+	})
+}
+
+// Writes a file by renaming a temporary file into place, so that readers never see a partially written file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns the hex-encoded SHA-256 digest of a file.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,185 @@
+package cheerio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beyang/cheerio/fetch"
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheerio-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := &PackageIndex{URI: "testdata/findlinks"}
+	graph := &PyPIGraph{Req: map[string][]string{"foo-bar": {"other"}}}
+	if err := Mirror(source, []*Requirement{{Name: "foo-bar"}}, dir, &MirrorOptions{Graph: graph}); err != nil {
+		t.Fatal(err)
+	}
+	// A second run adds to the mirror rather than replacing it
+	if err := Mirror(source, []*Requirement{{Name: "foo_bar", Constraint: "==", Version: "0.9"}}, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mirror := &PackageIndex{URI: dir}
+	pkgs, err := mirror.AllPackages()
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []string{"foo-bar", "other"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("want packages %v, got %v", want, pkgs)
+	}
+
+	releases, err := mirror.Releases("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Version != "0.9" || releases[1].Version != "1.0" {
+		t.Fatalf("want releases 0.9 and 1.0, got %+v", releases)
+	}
+	if digest := releases[1].Files[0].Hashes["sha256"]; len(digest) != 64 {
+		t.Errorf("want sha256 hash in mirror page, got %q", digest)
+	}
+
+	// Reading metadata checks the archive against the hash in the mirror page
+	modules, err := mirror.FetchSourceTopLevelModules("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(modules, []string{"foo_bar"}) {
+		t.Errorf("want top-level module foo_bar, got %v", modules)
+	}
+}
+
+func TestMirrorSamePackage(t *testing.T) {
+	dir := t.TempDir()
+	source := &PackageIndex{URI: "testdata/findlinks"}
+	reqs := []*Requirement{
+		{Name: "foo-bar", Constraint: "<", Version: "1.0"},
+		{Name: "other"},
+		{Name: "foo_bar", Constraint: ">=", Version: "1.0"},
+		{Name: "Foo-Bar", Constraint: "<", Version: "1.0"},
+	}
+	if err := Mirror(source, reqs, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	releases, err := (&PackageIndex{URI: dir}).Releases("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Version != "0.9" || releases[1].Version != "1.0" {
+		t.Errorf("want releases 0.9 and 1.0, one for each constraint, got %+v", releases)
+	}
+
+	if err := Mirror(source, []*Requirement{{Name: "other"}, {Name: "other", Constraint: ">", Version: "2.0"}}, t.TempDir(), nil); err == nil {
+		t.Errorf("want error for a constraint no release satisfies")
+	}
+}
+
+func TestMirrorRecheck(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0"}}})
+	defer server.Close()
+	dir := t.TempDir()
+	var downloads int
+	opts := &MirrorOptions{OnDownload: func(*DistFile) { downloads++ }}
+	mirror := func(source *PackageIndex) {
+		if err := Mirror(source, []*Requirement{{Name: "foo"}}, dir, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file that matches the index's hashes is kept; one that doesn't is downloaded again
+	mirror(&PackageIndex{URI: server.URL})
+	mirror(&PackageIndex{URI: server.URL})
+	if downloads != 1 {
+		t.Errorf("want 1 download for a file already mirrored, got %d", downloads)
+	}
+	path := filepath.Join(dir, "packages", pypitest.Filename("foo", "1.0", pypitest.Sdist))
+	if err := ioutil.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mirror(&PackageIndex{URI: server.URL})
+	if downloads != 2 {
+		t.Errorf("want a corrupt file to be downloaded again, got %d downloads", downloads)
+	}
+
+	// Without hashes, the file can't be checked, so it is downloaded again rather than trusted
+	unhashed := t.TempDir()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(unhashed, filepath.Base(path)), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mirror(&PackageIndex{URI: unhashed})
+	if downloads != 3 {
+		t.Errorf("want a file without hashes to be downloaded again, got %d downloads", downloads)
+	}
+	if err := fetch.VerifyFile(path, fetch.Hashes{"sha256": sha256Hex(data)}); err != nil {
+		t.Errorf("want the file from the index in the mirror, got %v", err)
+	}
+}
+
+func TestMirrorUnsafeFilename(t *testing.T) {
+	source := t.TempDir()
+	page := `<a href="../../packages/foo-1.0.tar.gz">foo-1.0.tar.gz/../../evil.tar.gz</a>`
+	if err := os.MkdirAll(filepath.Join(source, "simple", "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(source, "simple", "foo", "index.html"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Mirror(&PackageIndex{URI: source}, []*Requirement{{Name: "foo"}}, t.TempDir(), &MirrorOptions{AllVersions: true})
+	if err == nil || !strings.Contains(err.Error(), "not a plain file name") {
+		t.Errorf("want error for a filename with path elements, got %v", err)
+	}
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		if plainFilename(name) {
+			t.Errorf("%q: want not a plain file name", name)
+		}
+	}
+}
+
+func TestSelectReleases(t *testing.T) {
+	releases := []*Release{
+		{Version: "1.0", Files: []*DistFile{{}}},
+		{Version: "1.1", Files: []*DistFile{{}}},
+		{Version: "1.2", Files: []*DistFile{{Yanked: true}}},
+	}
+	tests := []struct {
+		req         *Requirement
+		wantVersion string
+	}{
+		{&Requirement{Name: "foo"}, "1.1"},
+		{&Requirement{Name: "foo", Constraint: "<", Version: "1.1"}, "1.0"},
+		{&Requirement{Name: "foo", Constraint: "==", Version: "1.2"}, "1.2"},
+		{&Requirement{Name: "foo", Constraint: ">", Version: "1.1"}, ""},
+	}
+	for _, test := range tests {
+		selected := selectReleases(releases, test.req, false)
+		var got string
+		if len(selected) > 0 {
+			got = selected[0].Version
+		}
+		if got != test.wantVersion {
+			t.Errorf("%s%s: want version %q, got %q", test.req.Constraint, test.req.Version, test.wantVersion, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cheerio
 
 import (
+	"context"
 	"regexp"
 	"strings"
 )
@@ -10,7 +11,15 @@ var topLevelTxtPattern = regexp.MustCompile(`(?:[^/]+/)*(?:[^/]*\.egg\-info/top_
 // Returns the top-level modules for a given PyPI package. This information is typically stored in the PyPI metadata, which is fetched from the remote
 // PyPI server. In some cases where the information is unavailable in the metadata, it has been hard-coded below.
 func (p *PackageIndex) FetchSourceTopLevelModules(pkg string) ([]string, error) {
-	b, err := p.FetchRawMetadata(pkg, topLevelTxtPattern, topLevelTxtPattern, topLevelTxtPattern)
+	return p.FetchSourceTopLevelModulesContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchSourceTopLevelModulesContext(ctx context.Context, pkg string) ([]string, error) {
+	return fetchSourceTopLevelModules(ctx, p, pkg)
+}
+
+func fetchSourceTopLevelModules(ctx context.Context, idx Index, pkg string) ([]string, error) {
+	files, err := idx.FetchMetadataFilesContext(ctx, pkg, topLevelTxtPattern, topLevelTxtPattern, topLevelTxtPattern)
 	if err != nil {
 		// If error, try to fall back to hard-coded top-level modules
 		if hardCodedModules, in := pypiTopLevelModules[pkg]; in {
@@ -20,13 +29,18 @@ func (p *PackageIndex) FetchSourceTopLevelModules(pkg string) ([]string, error)
 		}
 	}
 
+	return parseTopLevelTxt(topLevelFile(pkg, files)), nil
+}
+
+// Returns the module names listed in a top_level.txt file.
+func parseTopLevelTxt(b []byte) []string {
 	var modules []string
 	for _, line := range strings.Split(string(b), "\n") {
 		if module := strings.TrimSpace(line); module != "" {
 			modules = append(modules, module)
 		}
 	}
-	return modules, nil
+	return modules
 }
 
 var pypiTopLevelModules = map[string][]string{
@@ -1,44 +1,79 @@
 package cheerio
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"html"
 	"net/http"
-	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/beyang/cheerio/cache"
 	"github.com/beyang/cheerio/fetch"
-	"github.com/beyang/go-version"
 )
 
 var DefaultPyPI = &PackageIndex{URI: "https://pypi.python.org"}
 
 type PackageIndex struct {
 	URI string
+
+	// Credentials for the index. If nil, they are looked up as described in credentials().
+	Credentials *Credentials
+
+	// Whether the index may fall back to the credentials in the CHEERIO_INDEX_* environment variables. Set it on one index only (the command
+	// line sets it on the primary -index-url), so that the credentials don't reach other hosts.
+	EnvCredentials bool
+
+	// Makes the requests to the index and downloads archives. If nil, fetch.DefaultClient is used.
+	Client *fetch.Client
+
+	// If non-nil, index pages, archives and the metadata extracted from them are cached in it.
+	Cache *cache.Cache
+
+	// Fetches the pages of the index and the archives of its files. If nil, an HTTPFetcher using Client is used, with the index's credentials,
+	// behind a CachedFetcher if Cache is set. A Fetcher set here is used as is: Client, Credentials and Cache don't apply to it. If the index
+	// is a local directory without a simple/ tree, its files are listed from disk whatever the Fetcher, which then only reads their archives.
+	Fetcher Fetcher
+
+	// The number of recent metadata results to keep in memory, per index. So by default, up to DefaultMemoryCacheSize sets of metadata files
+	// stay in memory for as long as the index is in use; they are small, but set this to a negative number to keep none (e.g., for a
+	// long-running crawl that never asks for the same package twice). Callers get their own copies, so modifying a result is safe.
+	MemoryCacheSize int
+
+	// The code hosts whose repository URLs are recognized when inferring a package's source repository, tried in order. If nil,
+	// DefaultRepoHosts is used.
+	RepoHosts []*RepoHost
+
+	credsOnce sync.Once
+	creds     *Credentials
+
+	flights     flightGroup
+	resultsOnce sync.Once
+	results     *lruCache
 }
 
 // Get names of all packages served by a PyPI server.
 func (p *PackageIndex) AllPackages() ([]string, error) {
-	pkgs := make([]string, 0)
+	return p.AllPackagesContext(context.Background())
+}
 
-	resp, err := http.Get(fmt.Sprintf("%s/simple", p.URI))
-	if err != nil {
-		return nil, err
+// Like AllPackages, but gives up when ctx is done. So do the other XxxContext methods of PackageIndex and IndexSet: the context applies to all
+// of the requests they make and to reading the archives they extract from.
+func (p *PackageIndex) AllPackagesContext(ctx context.Context) ([]string, error) {
+	if dir, isFlat := p.flatDir(); isFlat {
+		return flatDirPackages(dir)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+
+	pkgs := make([]string, 0)
+
+	pg, err := p.fetcher().FetchPage(ctx, fmt.Sprintf("%s/simple/", p.baseURL()), "text/html", nil)
 	if err != nil {
 		return nil, err
 	}
-	matches := allPkgRegexp.FindAllStringSubmatch(string(body), -1)
-	for _, match := range matches {
-		if len(match) != 3 {
-			return nil, fmt.Errorf("Unexpected number of submatches: %d, %v", len(match), match)
-		} else if match[1] != match[2] {
-			return nil, fmt.Errorf("Names do not match %s != %s", match[1], match[2])
-		} else {
-			pkgs = append(pkgs, match[1])
+	for _, match := range anchorRegexp.FindAllSubmatch(pg.Body, -1) {
+		if pkg := strings.TrimSuffix(strings.TrimSpace(html.UnescapeString(string(match[2]))), "/"); pkg != "" {
+			pkgs = append(pkgs, pkg)
 		}
 	}
 
@@ -52,7 +87,15 @@ var requiresTxtZipPattern = requiresTxtTarPattern
 // Fetches package requirements from PyPI by downloading the package archive and extracting the requires.txt file.  If no such file exists (sometimes
 // it doesn't), returns an error.
 func (p *PackageIndex) FetchPackageRequirements(pkg string) ([]*Requirement, error) {
-	b, err := p.FetchRawMetadata(pkg, requiresTxtTarPattern, requiresTxtEggPattern, requiresTxtZipPattern)
+	return p.FetchPackageRequirementsContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchPackageRequirementsContext(ctx context.Context, pkg string) ([]*Requirement, error) {
+	return fetchPackageRequirements(ctx, p, pkg)
+}
+
+func fetchPackageRequirements(ctx context.Context, idx Index, pkg string) ([]*Requirement, error) {
+	files, err := idx.FetchMetadataFilesContext(ctx, pkg, requiresTxtTarPattern, requiresTxtEggPattern, requiresTxtZipPattern)
 	if err != nil {
 		if strings.Contains(err.Error(), "[no-files]") { // may not have a requires.txt
 			return nil, nil
@@ -60,61 +103,154 @@ func (p *PackageIndex) FetchPackageRequirements(pkg string) ([]*Requirement, err
 			return nil, err
 		}
 	}
-	return ParseRequirements(string(b))
+	return ParseRequirements(string(topLevelFile(pkg, files)))
 }
 
+// Downloads the latest archive of a package and returns the contents of the files in it that match the pattern for its type, concatenated in
+// path order. The archive is checked against the hashes published by the index; if they don't match, a *fetch.HashMismatchError is returned.
+// Returns a *fetch.NoMatchError if no file matches.
 func (p *PackageIndex) FetchRawMetadata(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error) {
-	files, err := p.pkgFiles(pkg)
+	return p.FetchRawMetadataContext(context.Background(), pkg, tarPattern, eggPattern, zipPattern)
+}
+
+func (p *PackageIndex) FetchRawMetadataContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) ([]byte, error) {
+	files, err := p.FetchMetadataFilesContext(ctx, pkg, tarPattern, eggPattern, zipPattern)
 	if err != nil {
 		return nil, err
-	} else if len(files) == 0 {
-		return nil, fmt.Errorf("[no-files] no files found for pkg %s", pkg)
 	}
+	return concatFiles(files), nil
+}
+
+// Like FetchRawMetadata, but returns the matching files separately, keyed by their path in the archive, so callers can tell the package's own
+// metadata from that of packages vendored in it (the package's own is usually the one nearest the root of the archive).
+func (p *PackageIndex) FetchMetadataFiles(pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error) {
+	return p.FetchMetadataFilesContext(context.Background(), pkg, tarPattern, eggPattern, zipPattern)
+}
+
+func (p *PackageIndex) FetchMetadataFilesContext(ctx context.Context, pkg string, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, error) {
+	files, err := p.pkgFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _, err := fetchLatestMetadataFiles(ctx, pkg, files, tarPattern, eggPattern, zipPattern)
+	return metadata, err
+}
+
+// Does the work of FetchMetadataFiles given the files of a package, as returned by pkgFiles. Also returns the file the metadata was read from.
+func fetchLatestMetadataFiles(ctx context.Context, pkg string, files []*DistFile, tarPattern, eggPattern, zipPattern *regexp.Regexp) (fetch.Files, *DistFile, error) {
+	file, kind, err := latestMetadataArchive(pkg, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pattern *regexp.Regexp
+	switch kind {
+	case tarArchive:
+		pattern = tarPattern
+	case eggArchive:
+		pattern = eggPattern
+	case zipArchive:
+		pattern = zipPattern
+	}
+	metadata, err := file.extract(ctx, pattern, kind.compressionType())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, nil, &fetch.NoMatchError{Pattern: pattern}
+	}
+	return metadata, file, nil
+}
 
-	// Sort files in version order
-	version.Sort(files)
+// The kinds of archive that metadata is read from.
+type archiveKind int
 
-	// Get the latest version
-	if path := lastTar(files); path != "" {
-		return fetch.RemoteDecompress(fmt.Sprintf("%s%s", p.URI, path), tarPattern, fetch.Tar)
-	} else if path := lastEgg(files); path != "" {
-		return fetch.RemoteDecompress(fmt.Sprintf("%s%s", p.URI, path), eggPattern, fetch.Zip)
-	} else if path := lastZip(files); path != "" {
-		return fetch.RemoteDecompress(fmt.Sprintf("%s%s", p.URI, path), zipPattern, fetch.Zip)
-	} else {
-		return nil, fmt.Errorf("[tar/zip] no tar or zip found in %+v for pkg %s", files, pkg)
+const (
+	tarArchive archiveKind = iota // sdist tarball, with metadata in <name>-<version>/<name>.egg-info/
+	eggArchive                    // egg, with metadata in EGG-INFO/
+	zipArchive                    // zipped sdist, laid out like a tarball
+)
+
+func (k archiveKind) compressionType() fetch.CompressionType {
+	if k == tarArchive {
+		return fetch.Tar
+	}
+	return fetch.Zip
+}
+
+// Returns the archive of the latest release to read metadata from: the latest tarball if there is one, otherwise the latest egg, otherwise
+// the latest zip.
+func latestMetadataArchive(pkg string, files []*DistFile) (*DistFile, archiveKind, error) {
+	if len(files) == 0 {
+		return nil, 0, fmt.Errorf("[no-files] no files found for pkg %s", pkg)
+	}
+	if file := lastTar(files); file != nil {
+		return file, tarArchive, nil
+	} else if file = lastEgg(files); file != nil {
+		return file, eggArchive, nil
+	} else if file = lastZip(files); file != nil {
+		return file, zipArchive, nil
 	}
+	return nil, 0, fmt.Errorf("[tar/zip] no tar or zip found in %s for pkg %s", filenames(files), pkg)
 }
 
-var allPkgRegexp = regexp.MustCompile(`<a href='([A-Za-z0-9\._\-]+)'>([A-Za-z0-9\._\-]+)</a><br/>`)
-var pkgFilesRegexp = regexp.MustCompile(`<a href="([/A-Za-z0-9\._\-]+)#md5=[0-9a-z]+"[^>]*>([A-Za-z0-9\._\-]+)</a><br/>`)
 var requirementRegexp = regexp.MustCompile(`(?P<package>[A-Za-z0-9\._\-]+)(?:\[([A-Za-z0-9\._\-]+)\])?\s*(?:(?P<constraint>==|>=|>|<|<=)\s*(?P<version>[A-Za-z0-9\._\-]+)(?:\s*,\s*[<>=!]+\s*[a-z0-9\.]+)?)?`)
 var reqHeaderRegexp = regexp.MustCompile(`\[[A-Za-z0-9\._\-]+\]`)
 
 // Helpers
 
-func (p *PackageIndex) pkgFiles(pkg string) ([]string, error) {
-	files := make([]string, 0)
-
-	uriPath := fmt.Sprintf("/simple/%s", pkg)
-	uri := fmt.Sprintf("%s%s", p.URI, uriPath)
-	resp, err := http.Get(uri)
-	if err != nil {
-		return nil, err
+// Returns the client for requests to the index, which adds the index credentials to requests addressed to the index host. Only a local index
+// may read file:// URLs.
+func (p *PackageIndex) client() *fetch.Client {
+	base := fetch.DefaultClient
+	if p.Client != nil {
+		base = p.Client
+	}
+	c := *base
+	if _, isLocal := p.localDir(); isLocal {
+		c.AllowFileURLs = true
+	}
+	c.Authorize = func(req *http.Request) {
+		if base.Authorize != nil {
+			base.Authorize(req)
+		}
+		p.authorize(req)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	return &c
+}
+
+func (p *PackageIndex) repoHosts() []*RepoHost {
+	return repoHostsOrDefault(p.RepoHosts)
+}
+
+// Returns the files of a package in version order, leaving out yanked files unless every file has been yanked (PEP 592).
+func (p *PackageIndex) pkgFiles(ctx context.Context, pkg string) ([]*DistFile, error) {
+	files, err := p.distFiles(ctx, pkg)
 	if err != nil {
 		return nil, err
 	}
-	matches := pkgFilesRegexp.FindAllStringSubmatch(string(body), -1)
-	for _, match := range matches {
-		if len(match) != 3 {
-			return nil, fmt.Errorf("Unexpected number of submatches: %d, %v", len(match), match)
-		} else {
-			files = append(files, filepath.Clean(filepath.Join(uriPath, match[1])))
+	return installableFiles(files), nil
+}
+
+// Sorts files in version order and removes yanked files, unless every file has been yanked.
+func installableFiles(files []*DistFile) []*DistFile {
+	sortDistFiles(files)
+
+	unyanked := make([]*DistFile, 0, len(files))
+	for _, file := range files {
+		if !file.Yanked {
+			unyanked = append(unyanked, file)
 		}
 	}
+	if len(unyanked) == 0 {
+		return files
+	}
+	return unyanked
+}
 
-	return files, nil
+func filenames(files []*DistFile) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Filename
+	}
+	return names
 }
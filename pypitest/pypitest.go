@@ -0,0 +1,298 @@
+// Package pypitest provides a fake PyPI server for tests. It serves a simple index (PEP 503) for a configurable set of projects, and builds
+// their sdist, egg and zip archives on the fly, containing the given PKG-INFO, requires.txt and top_level.txt.
+//
+//	server := pypitest.NewServer(&pypitest.Project{
+//		Name:     "flask",
+//		Releases: []*pypitest.Release{{Version: "0.10.1", RequiresTxt: "Werkzeug>=0.7\n"}},
+//	})
+//	defer server.Close()
+//	index := &cheerio.PackageIndex{URI: server.URL}
+package pypitest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kind of archive a release is distributed as.
+type Format string
+
+const (
+	Sdist Format = "tar.gz" // <name>-<version>.tar.gz, with metadata in <name>-<version>/<name>.egg-info/
+	Zip   Format = "zip"    // <name>-<version>.zip, laid out like Sdist
+	Egg   Format = "egg"    // <name>-<version>-py2.7.egg, with metadata in EGG-INFO/
+)
+
+// A fake project.
+type Project struct {
+	Name     string
+	Releases []*Release
+}
+
+// A release of a fake project.
+type Release struct {
+	Version string
+
+	// Contents of PKG-INFO. If empty, a minimal PKG-INFO with the name and version is generated.
+	PKGInfo string
+
+	// Contents of requires.txt and top_level.txt. Empty files are left out of the archive.
+	RequiresTxt string
+	TopLevelTxt string
+
+	// Other files to put in the archive, keyed by path relative to the archive root (e.g., "setup.py").
+	ExtraFiles map[string]string
+
+	// The archives to serve. Defaults to a single Sdist.
+	Formats []Format
+
+	// Served as data-requires-python and data-yanked.
+	RequiresPython string
+	Yanked         bool
+	YankedReason   string
+}
+
+// A fake PyPI server. Its URL is the base URI of a package index.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	projects map[string]*Project
+	files    map[string]*file // keyed by filename
+	requests map[string]int   // keyed by path
+	sent     map[string]int64 // bytes of archives sent, keyed by path
+}
+
+type file struct {
+	release *Release
+	data    []byte
+	sha256  string
+}
+
+// Starts a server for the given projects. Call Close when done.
+func NewServer(projects ...*Project) *Server {
+	s := &Server{
+		projects: make(map[string]*Project),
+		files:    make(map[string]*file),
+		requests: make(map[string]int),
+		sent:     make(map[string]int64),
+	}
+	for _, project := range projects {
+		s.AddProject(project)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Adds a project, replacing any project of the same (normalized) name. Its archives are built immediately.
+func (s *Server) AddProject(project *Project) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, in := s.projects[normalize(project.Name)]; in {
+		for filename, f := range s.files {
+			for _, release := range old.Releases {
+				if f.release == release {
+					delete(s.files, filename)
+				}
+			}
+		}
+	}
+	s.projects[normalize(project.Name)] = project
+	for _, release := range project.Releases {
+		for _, format := range formats(release) {
+			data := buildArchive(project.Name, release, format)
+			sum := sha256.Sum256(data)
+			s.files[Filename(project.Name, release.Version, format)] = &file{release: release, data: data, sha256: hex.EncodeToString(sum[:])}
+		}
+	}
+}
+
+// Returns the number of requests made for a path, e.g., "/simple/flask/" or "/packages/flask-0.10.1.tar.gz".
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// Returns the number of bytes of an archive sent in responses, e.g., for "/packages/flask-0.10.1.tar.gz". Archives are served with support for
+// Range requests, so this is less than the archive's size times the number of requests if a client reads only parts of it.
+func (s *Server) BytesSent(path string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent[path]
+}
+
+// Returns the filename of a release archive.
+func Filename(name, version string, format Format) string {
+	if format == Egg {
+		return fmt.Sprintf("%s-%s-py2.7.egg", eggName(name), version)
+	}
+	return fmt.Sprintf("%s-%s.%s", name, version, format)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	page, archive, found := s.respond(r.URL.Path)
+	if !found {
+		http.NotFound(w, r)
+	} else if archive != nil {
+		cw := &countingWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, r.URL.Path, time.Time{}, bytes.NewReader(archive))
+		s.mu.Lock()
+		s.sent[r.URL.Path] += cw.n
+		s.mu.Unlock()
+	} else {
+		w.Write(page)
+	}
+}
+
+// Returns the page or archive at a path, and counts the request. The lock is only held while the response is put together, not while it is
+// written, so a slow client doesn't hold up others. Archives are never modified once built, so they can be served after the lock is released.
+func (s *Server) respond(path string) (page, archive []byte, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[path]++
+
+	var buf bytes.Buffer
+	switch {
+	case path == "/simple/" || path == "/simple":
+		var names []string
+		for _, project := range s.projects {
+			names = append(names, project.Name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(&buf, "<html><body>")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "<a href=\"%s/\">%s</a><br/>\n", html.EscapeString(normalize(name)), html.EscapeString(name))
+		}
+		fmt.Fprintln(&buf, "</body></html>")
+		return buf.Bytes(), nil, true
+
+	case strings.HasPrefix(path, "/simple/"):
+		project, in := s.projects[normalize(strings.Trim(strings.TrimPrefix(path, "/simple/"), "/"))]
+		if !in {
+			return nil, nil, false
+		}
+		fmt.Fprintf(&buf, "<html><body><h1>Links for %s</h1>\n", html.EscapeString(project.Name))
+		for _, release := range project.Releases {
+			for _, format := range formats(release) {
+				filename := Filename(project.Name, release.Version, format)
+				fmt.Fprintf(&buf, "<a href=\"../../packages/%s#sha256=%s\"", html.EscapeString(filename), s.files[filename].sha256)
+				if release.RequiresPython != "" {
+					fmt.Fprintf(&buf, " data-requires-python=\"%s\"", html.EscapeString(release.RequiresPython))
+				}
+				if release.Yanked {
+					fmt.Fprintf(&buf, " data-yanked=\"%s\"", html.EscapeString(release.YankedReason))
+				}
+				fmt.Fprintf(&buf, ">%s</a><br/>\n", html.EscapeString(filename))
+			}
+		}
+		fmt.Fprintln(&buf, "</body></html>")
+		return buf.Bytes(), nil, true
+
+	case strings.HasPrefix(path, "/packages/"):
+		f, in := s.files[strings.TrimPrefix(path, "/packages/")]
+		if !in {
+			return nil, nil, false
+		}
+		return nil, f.data, true
+	}
+	return nil, nil, false
+}
+
+// Counts the bytes of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func formats(release *Release) []Format {
+	if len(release.Formats) == 0 {
+		return []Format{Sdist}
+	}
+	return release.Formats
+}
+
+// Builds an archive for a release, containing its metadata files.
+func buildArchive(name string, release *Release, format Format) []byte {
+	pkgInfo := release.PKGInfo
+	if pkgInfo == "" {
+		pkgInfo = fmt.Sprintf("Metadata-Version: 1.0\nName: %s\nVersion: %s\n", name, release.Version)
+	}
+	metadata := map[string]string{"PKG-INFO": pkgInfo, "requires.txt": release.RequiresTxt, "top_level.txt": release.TopLevelTxt}
+
+	root := fmt.Sprintf("%s-%s/", name, release.Version)
+	infoDir := root + eggName(name) + ".egg-info/"
+	if format == Egg {
+		root, infoDir = "", "EGG-INFO/"
+	}
+	files := map[string]string{}
+	if format != Egg {
+		files[root+"PKG-INFO"] = pkgInfo
+	}
+	for filename, contents := range metadata {
+		if contents != "" {
+			files[infoDir+filename] = contents
+		}
+	}
+	for path, contents := range release.ExtraFiles {
+		files[root+path] = contents
+	}
+
+	// Write files in a fixed order, so archives (and their hashes) are reproducible
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	if format == Sdist {
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, path := range paths {
+			tw.WriteHeader(&tar.Header{Name: path, Mode: 0644, Size: int64(len(files[path])), Typeflag: tar.TypeReg})
+			tw.Write([]byte(files[path]))
+		}
+		tw.Close()
+		gz.Close()
+	} else {
+		zw := zip.NewWriter(&buf)
+		for _, path := range paths {
+			w, _ := zw.Create(path)
+			w.Write([]byte(files[path]))
+		}
+		zw.Close()
+	}
+	return buf.Bytes()
+}
+
+var normalizeRegexp = regexp.MustCompile(`[\-_\.]+`)
+
+// Normalizes a project name as described in PEP 503.
+func normalize(name string) string {
+	return normalizeRegexp.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// Returns the name used for egg-info directories and egg files, in which dashes are escaped as underscores.
+func eggName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
@@ -0,0 +1,172 @@
+package pypitest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url string) (int, []byte) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+var linkRegexp = regexp.MustCompile(`href="\.\./\.\./packages/([^"#]+)#sha256=([0-9a-f]+)"`)
+
+func TestServer(t *testing.T) {
+	server := NewServer(&Project{Name: "Foo_Bar", Releases: []*Release{
+		{Version: "1.0", RequiresTxt: "six\n", Formats: []Format{Sdist, Egg}},
+		{Version: "2.0", PKGInfo: "Metadata-Version: 2.1\nName: Foo_Bar\nVersion: 2.0\n", Formats: []Format{Zip}, Yanked: true},
+	}})
+	defer server.Close()
+
+	if status, body := get(t, server.URL+"/simple/"); status != http.StatusOK || !strings.Contains(string(body), `<a href="foo-bar/">Foo_Bar</a>`) {
+		t.Errorf("unexpected root page (%d):\n%s", status, body)
+	}
+	status, page := get(t, server.URL+"/simple/foo.bar/")
+	if status != http.StatusOK {
+		t.Fatalf("want 200 for a project under another spelling of its name, got %d", status)
+	}
+	if !strings.Contains(string(page), `data-yanked=""`) {
+		t.Errorf("want 2.0 yanked, got:\n%s", page)
+	}
+
+	links := linkRegexp.FindAllStringSubmatch(string(page), -1)
+	want := []string{"Foo_Bar-1.0.tar.gz", "Foo_Bar-1.0-py2.7.egg", "Foo_Bar-2.0.zip"}
+	if len(links) != len(want) {
+		t.Fatalf("want links to %v, got:\n%s", want, page)
+	}
+	for i, link := range links {
+		if link[1] != want[i] {
+			t.Errorf("want link to %s, got %s", want[i], link[1])
+		}
+		status, archive := get(t, server.URL+"/packages/"+link[1])
+		sum := sha256.Sum256(archive)
+		if status != http.StatusOK || hex.EncodeToString(sum[:]) != link[2] {
+			t.Errorf("%s: want an archive matching the linked hash, got status %d", link[1], status)
+		}
+	}
+	if n := server.Requests("/packages/Foo_Bar-1.0.tar.gz"); n != 1 {
+		t.Errorf("want 1 request for the sdist, got %d", n)
+	}
+
+	// The sdist holds the metadata in its egg-info directory
+	_, sdist := get(t, server.URL+"/packages/Foo_Bar-1.0.tar.gz")
+	gz, err := gzip.NewReader(bytes.NewReader(sdist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		b, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+	if files["Foo_Bar-1.0/Foo_Bar.egg-info/requires.txt"] != "six\n" || !strings.Contains(files["Foo_Bar-1.0/PKG-INFO"], "Version: 1.0") {
+		t.Errorf("unexpected sdist contents: %v", files)
+	}
+
+	// So does the zip, with the given PKG-INFO
+	_, zipData := get(t, server.URL+"/packages/Foo_Bar-2.0.zip")
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "Foo_Bar-2.0/Foo_Bar.egg-info/PKG-INFO Foo_Bar-2.0/PKG-INFO" {
+		t.Errorf("unexpected zip contents: %v", names)
+	}
+
+	// Archives support Range requests
+	req, _ := http.NewRequest("GET", server.URL+"/packages/Foo_Bar-2.0.zip", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(head) != "PK\x03\x04" {
+		t.Errorf("want the first 4 bytes of the zip, got %d %q", resp.StatusCode, head)
+	}
+
+	// Replacing a project drops the archives of its old releases
+	server.AddProject(&Project{Name: "foo-bar", Releases: []*Release{{Version: "3.0"}}})
+	if status, _ := get(t, server.URL+"/packages/Foo_Bar-1.0.tar.gz"); status != http.StatusNotFound {
+		t.Errorf("want 404 for a replaced release, got %d", status)
+	}
+	if status, _ := get(t, server.URL+"/packages/foo-bar-3.0.tar.gz"); status != http.StatusOK {
+		t.Errorf("want 200 for the new release, got %d", status)
+	}
+	if status, _ := get(t, server.URL+"/simple/nope/"); status != http.StatusNotFound {
+		t.Errorf("want 404 for an unknown project, got %d", status)
+	}
+}
+
+// A response writer that blocks on writing until released, like a client that stops reading.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{} // closed once a write is blocked
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestServerSlowClient(t *testing.T) {
+	server := NewServer(&Project{Name: "foo", Releases: []*Release{{Version: "1.0"}}})
+	defer server.Close()
+
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	stalled := make(chan struct{})
+	go func() {
+		server.serveHTTP(w, httptest.NewRequest("GET", "/packages/foo-1.0.tar.gz", nil))
+		close(stalled)
+	}()
+	<-w.writing
+
+	done := make(chan error)
+	go func() {
+		resp, err := http.Get(server.URL + "/simple/foo/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a stalled download blocked other requests")
+	}
+	close(w.release)
+	<-stalled
+}
@@ -0,0 +1,202 @@
+package cheerio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// A Recorder saves each response of another Fetcher in a directory, one JSON file per request (plus a copy of each downloaded archive), and a
+// Replayer answers the same requests from those files without touching the network. Failures are recorded too, so that tests can replay
+// error paths: an HTTP error status (such as for a package that doesn't exist) is replayed as a *fetch.StatusError, and any other error as a
+// *RecordedError with the same message. Requests whose context was done aren't recorded, as their failure says nothing about the index.
+
+// Records the traffic of another Fetcher in a directory, for a Replayer to play back.
+type Recorder struct {
+	Fetcher Fetcher
+	Dir     string
+}
+
+// Plays back the traffic recorded by a Recorder. Requests that weren't recorded fail with a *NotRecordedError.
+type Replayer struct {
+	Dir string
+}
+
+// Returned by a Replayer for a request that wasn't recorded.
+type NotRecordedError struct {
+	Request string
+}
+
+func (e *NotRecordedError) Error() string {
+	return fmt.Sprintf("No recording of %s", e.Request)
+}
+
+// Returned by a Replayer for a request that failed when it was recorded, other than with an HTTP error status.
+type RecordedError struct {
+	Request string
+	Message string // the message of the original error
+}
+
+func (e *RecordedError) Error() string {
+	return e.Message
+}
+
+// A recorded response.
+type recording struct {
+	Request    string      // describes the request, for people reading the recordings
+	Page       *IndexPage  `json:",omitempty"`
+	Files      fetch.Files `json:",omitempty"`
+	StatusCode int         `json:",omitempty"` // of a *fetch.StatusError
+	Status     string      `json:",omitempty"`
+	Error      string      `json:",omitempty"` // the message of any other error
+}
+
+func pageRequest(uri, accept string) string {
+	return fmt.Sprintf("GET %s (Accept: %s)", uri, accept)
+}
+
+func extractRequest(file *DistFile, pattern *regexp.Regexp) string {
+	return fmt.Sprintf("extract %s from %s", pattern, file.URL)
+}
+
+func downloadRequest(file *DistFile) string {
+	return "download " + file.URL
+}
+
+// Returns the path of the recording of a request, without its extension.
+func recordingPath(dir, request string) string {
+	sum := sha256.Sum256([]byte(request))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16]))
+}
+
+// Records the outcome of a request, unless it failed because ctx was done.
+func (r *Recorder) record(ctx context.Context, rec *recording, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	if statusErr, ok := err.(*fetch.StatusError); ok {
+		rec.StatusCode, rec.Status = statusErr.StatusCode, statusErr.Status
+	} else if err != nil {
+		rec.Error = err.Error()
+	}
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(recordingPath(r.Dir, rec.Request)+".json", func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+func (r *Recorder) FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error) {
+	pg, err := r.Fetcher.FetchPage(ctx, uri, accept, stale)
+	if recordErr := r.record(ctx, &recording{Request: pageRequest(uri, accept), Page: pg}, err); recordErr != nil {
+		return nil, recordErr
+	}
+	return pg, err
+}
+
+func (r *Recorder) ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	files, err := r.Fetcher.ExtractFiles(ctx, file, pattern, compressionType)
+	if recordErr := r.record(ctx, &recording{Request: extractRequest(file, pattern), Files: files}, err); recordErr != nil {
+		return nil, recordErr
+	}
+	return files, err
+}
+
+func (r *Recorder) DownloadFile(ctx context.Context, file *DistFile, path string) error {
+	err := r.Fetcher.DownloadFile(ctx, file, path)
+	request := downloadRequest(file)
+	if err == nil {
+		// The recording only exists once the archive is in place beside it
+		if err := os.MkdirAll(r.Dir, 0755); err != nil {
+			return err
+		}
+		if err := copyFile(path, recordingPath(r.Dir, request)+".archive"); err != nil {
+			return err
+		}
+	}
+	if recordErr := r.record(ctx, &recording{Request: request}, err); recordErr != nil {
+		return recordErr
+	}
+	return err
+}
+
+// Returns the recording of a request, and the error it recorded, if any.
+func (r *Replayer) replay(request, uri string) (*recording, error) {
+	b, err := ioutil.ReadFile(recordingPath(r.Dir, request) + ".json")
+	if os.IsNotExist(err) {
+		return nil, &NotRecordedError{Request: request}
+	} else if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("Error reading the recording of %s: %w", request, err)
+	}
+	if rec.StatusCode != 0 {
+		return nil, &fetch.StatusError{URI: uri, StatusCode: rec.StatusCode, Status: rec.Status}
+	} else if rec.Error != "" {
+		return nil, &RecordedError{Request: request, Message: rec.Error}
+	}
+	return &rec, nil
+}
+
+func (r *Replayer) FetchPage(ctx context.Context, uri, accept string, stale *IndexPage) (*IndexPage, error) {
+	rec, err := r.replay(pageRequest(uri, accept), uri)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Page == nil {
+		return nil, fmt.Errorf("The recording of %s has no page", rec.Request)
+	}
+	return rec.Page, nil
+}
+
+// Returns the recorded files, which the recorded fetcher checked against the file's hashes; there is no archive to check them again.
+func (r *Replayer) ExtractFiles(ctx context.Context, file *DistFile, pattern *regexp.Regexp, compressionType fetch.CompressionType) (fetch.Files, error) {
+	rec, err := r.replay(extractRequest(file, pattern), file.URL)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Files == nil {
+		return fetch.Files{}, nil
+	}
+	return rec.Files, nil
+}
+
+// Copies the recorded archive to path, checking it against the file's hashes, so a recording that doesn't match the index fails as the
+// download would.
+func (r *Replayer) DownloadFile(ctx context.Context, file *DistFile, path string) error {
+	request := downloadRequest(file)
+	if _, err := r.replay(request, file.URL); err != nil {
+		return err
+	}
+	return fileClient(fetch.DefaultClient).DownloadFile(ctx, fileURL(recordingPath(r.Dir, request)+".archive"), path, file.Hashes)
+}
+
+// Copies a file, atomically replacing the destination.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
@@ -0,0 +1,87 @@
+package cheerio
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A released version of a package and the files uploaded for it.
+type Release struct {
+	Version string
+	Files   []*DistFile
+}
+
+// Returns the earliest upload time of the release's files, or the zero time if the index doesn't report upload times.
+func (r *Release) UploadTime() time.Time {
+	var earliest time.Time
+	for _, file := range r.Files {
+		if !file.UploadTime.IsZero() && (earliest.IsZero() || file.UploadTime.Before(earliest)) {
+			earliest = file.UploadTime
+		}
+	}
+	return earliest
+}
+
+// Returns the Python versions the release supports, as given by the data-requires-python of its files (or "" if unspecified).
+func (r *Release) RequiresPython() string {
+	for _, file := range r.Files {
+		if file.RequiresPython != "" {
+			return file.RequiresPython
+		}
+	}
+	return ""
+}
+
+// A release is yanked if all of its files are yanked (PEP 592).
+func (r *Release) Yanked() bool {
+	for _, file := range r.Files {
+		if !file.Yanked {
+			return false
+		}
+	}
+	return len(r.Files) > 0
+}
+
+// Returns the reason given for yanking the release, if any.
+func (r *Release) YankedReason() string {
+	for _, file := range r.Files {
+		if file.YankedReason != "" {
+			return file.YankedReason
+		}
+	}
+	return ""
+}
+
+// Lists the releases of a package served by a PyPI server, in ascending version order. Yanked releases are included; check Release.Yanked.
+func (p *PackageIndex) Releases(pkg string) ([]*Release, error) {
+	return p.ReleasesContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) ReleasesContext(ctx context.Context, pkg string) ([]*Release, error) {
+	files, err := p.distFiles(ctx, pkg)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("[no-files] no files found for pkg %s", pkg)
+	}
+	return groupReleases(files), nil
+}
+
+// Groups files by version, in ascending version order. Files whose version can't be determined from the filename are left out.
+func groupReleases(files []*DistFile) []*Release {
+	sortDistFiles(files)
+
+	var releases []*Release
+	for _, file := range files {
+		if file.Version == "" {
+			continue
+		}
+		if len(releases) == 0 || releases[len(releases)-1].Version != file.Version {
+			releases = append(releases, &Release{Version: file.Version})
+		}
+		last := releases[len(releases)-1]
+		last.Files = append(last.Files, file)
+	}
+	return releases
+}
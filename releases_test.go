@@ -0,0 +1,91 @@
+package cheerio
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseSimpleHTML(t *testing.T) {
+	base, _ := url.Parse("https://example.com/simple/foo-bar/")
+	files, err := parseSimpleHTML(base, []byte(`<html><body>
+<a href="../../packages/Foo-Bar-1.0.tar.gz#md5=abc&egg=Foo-Bar">Foo-Bar-1.0.tar.gz</a><br/>
+<a href="https://files.example.com/foo_bar-2.0-py3-none-any.whl#sha256=def" data-requires-python="&gt;=3.6">foo_bar-2.0-py3-none-any.whl</a><br/>
+<a href='/packages/Foo-Bar-2.0.tar.gz' data-requires-python="&gt;=3.6" data-yanked>Foo-Bar-2.0.tar.gz</a><br/>
+<a href="/packages/Foo-Bar-2.1.zip" data-yanked="broken build">Foo-Bar-2.1.zip</a><br/>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file.Version = versionFromFilename("foo-bar", file.Filename)
+	}
+	releases := groupReleases(files)
+
+	if len(releases) != 3 {
+		t.Fatalf("want 3 releases, got %d", len(releases))
+	}
+	if got := releases[0].Files[0].URL; got != "https://example.com/packages/Foo-Bar-1.0.tar.gz" {
+		t.Errorf("want resolved URL, got %q", got)
+	}
+	if got := releases[0].Files[0].Hashes; len(got) != 1 || got["md5"] != "abc" {
+		t.Errorf("want only the md5 hash from the fragment, got %v", got)
+	}
+	if v := releases[1].Version; v != "2.0" || len(releases[1].Files) != 2 {
+		t.Errorf("want release 2.0 with 2 files, got %s with %d", v, len(releases[1].Files))
+	}
+	if rp := releases[1].RequiresPython(); rp != ">=3.6" {
+		t.Errorf("want requires-python >=3.6, got %q", rp)
+	}
+	if releases[1].Yanked() {
+		t.Errorf("release 2.0 has an unyanked file and should not be yanked")
+	}
+	if !releases[2].Yanked() || releases[2].YankedReason() != "broken build" {
+		t.Errorf("want release 2.1 yanked for \"broken build\", got %v %q", releases[2].Yanked(), releases[2].YankedReason())
+	}
+}
+
+func TestParseSimpleJSON(t *testing.T) {
+	base, _ := url.Parse("https://example.com/simple/foo/")
+	files, err := parseSimpleJSON(base, []byte(`{
+  "meta": {"api-version": "1.1"},
+  "name": "foo",
+  "files": [
+    {"filename": "foo-1.0.tar.gz", "url": "/packages/foo-1.0.tar.gz", "hashes": {}, "yanked": false, "upload-time": "2013-06-13T12:00:00.123456Z"},
+    {"filename": "foo-1.1.tar.gz", "url": "/packages/foo-1.1.tar.gz", "hashes": {}, "requires-python": ">=3", "yanked": "oops"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("want 2 files, got %d", len(files))
+	}
+	if want := time.Date(2013, 6, 13, 12, 0, 0, 123456000, time.UTC); !files[0].UploadTime.Equal(want) {
+		t.Errorf("want upload time %v, got %v", want, files[0].UploadTime)
+	}
+	if files[0].Yanked || !files[1].Yanked || files[1].YankedReason != "oops" {
+		t.Errorf("bad yanked status: %+v %+v", files[0], files[1])
+	}
+	if files[1].RequiresPython != ">=3" {
+		t.Errorf("want requires-python >=3, got %q", files[1].RequiresPython)
+	}
+}
+
+func TestVersionFromFilename(t *testing.T) {
+	tests := []struct {
+		pkg, filename, wantVersion string
+	}{
+		{"flask", "Flask-0.10.1.tar.gz", "0.10.1"},
+		{"django-cms", "django-cms-2.4.0-rc1.tar.gz", "2.4.0-rc1"},
+		{"zope.interface", "zope.interface-4.0.5.zip", "4.0.5"},
+		{"python-dateutil", "python_dateutil-2.2-py2.py3-none-any.whl", "2.2"},
+		{"setuptools", "setuptools-0.9.8-py2.7.egg", "0.9.8"},
+		{"foo", "README.txt", ""},
+	}
+	for _, test := range tests {
+		if got := versionFromFilename(test.pkg, test.filename); got != test.wantVersion {
+			t.Errorf("%s: want version %q, got %q", test.filename, test.wantVersion, got)
+		}
+	}
+}
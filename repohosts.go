@@ -0,0 +1,255 @@
+package cheerio
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The kinds of code hosting software, which differ in how the URL of a repository is laid out.
+type HostKind int
+
+const (
+	HostGitHub     HostKind = iota // github.com/<owner>/<name>
+	HostBitbucket                  // bitbucket.org/<owner>/<name>
+	HostGitea                      // Gitea and Forgejo, such as codeberg.org: <host>/<owner>/<name>
+	HostGitLab                     // <host>/<group>/[<subgroup>/...]<name>
+	HostSourcehut                  // git.sr.ht/~<owner>/<name>
+	HostLaunchpad                  // launchpad.net/<project>, or ~<owner>/<project>/+git/<name> and ~<owner>/<project>/<branch>
+	HostGoogleCode                 // code.google.com/p/<name>
+)
+
+var hostKindNames = map[HostKind]string{
+	HostGitHub:     "github",
+	HostBitbucket:  "bitbucket",
+	HostGitea:      "gitea",
+	HostGitLab:     "gitlab",
+	HostSourcehut:  "sourcehut",
+	HostLaunchpad:  "launchpad",
+	HostGoogleCode: "googlecode",
+}
+
+func (k HostKind) String() string {
+	if name, in := hostKindNames[k]; in {
+		return name
+	}
+	return fmt.Sprintf("HostKind(%d)", int(k))
+}
+
+// Parses the name of a HostKind, as returned by its String method (e.g., "gitlab").
+func ParseHostKind(name string) (HostKind, error) {
+	for kind, kindName := range hostKindNames {
+		if strings.EqualFold(name, kindName) {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown kind of code host %q", name)
+}
+
+// A code host, or a set of them, whose repository URLs are recognized when inferring a package's repository.
+type RepoHost struct {
+	// The host name, which may contain the wildcards of path.Match (e.g., "git.example.com" or "*.git.example.com").
+	Pattern string
+
+	Kind HostKind
+}
+
+// The code hosts recognized by default: by ParseRepoRef, and by a PackageIndex or IndexSet whose RepoHosts is nil. GitLab instances are
+// commonly served from a "gitlab." subdomain, so those are recognized too. Don't modify it; to recognize internal hosts, set RepoHosts on the
+// index instead, e.g.:
+//
+//	index.RepoHosts = append([]*cheerio.RepoHost{{Pattern: "git.example.com", Kind: cheerio.HostGitLab}}, cheerio.DefaultRepoHosts...)
+//
+// Whatever the hosts, a URL whose path has an element ending in ".git" is taken to be a repository up to that element.
+var DefaultRepoHosts = []*RepoHost{
+	{Pattern: "github.com", Kind: HostGitHub},
+	{Pattern: "www.github.com", Kind: HostGitHub},
+	{Pattern: "bitbucket.org", Kind: HostBitbucket},
+	{Pattern: "www.bitbucket.org", Kind: HostBitbucket},
+	{Pattern: "gitlab.com", Kind: HostGitLab},
+	{Pattern: "gitlab.*", Kind: HostGitLab},
+	{Pattern: "codeberg.org", Kind: HostGitea},
+	{Pattern: "gitea.com", Kind: HostGitea},
+	{Pattern: "git.sr.ht", Kind: HostSourcehut},
+	{Pattern: "hg.sr.ht", Kind: HostSourcehut},
+	{Pattern: "launchpad.net", Kind: HostLaunchpad},
+	{Pattern: "code.launchpad.net", Kind: HostLaunchpad},
+	{Pattern: "git.launchpad.net", Kind: HostLaunchpad},
+	{Pattern: "code.google.com", Kind: HostGoogleCode},
+}
+
+// Returns the given code hosts, or DefaultRepoHosts if there are none.
+func repoHostsOrDefault(hosts []*RepoHost) []*RepoHost {
+	if hosts == nil {
+		return DefaultRepoHosts
+	}
+	return hosts
+}
+
+// Returns the first of the hosts that matches a host name, or nil if none does.
+func findRepoHost(hosts []*RepoHost, hostname string) *RepoHost {
+	hostname = strings.ToLower(hostname)
+	for _, host := range hosts {
+		if matched, _ := path.Match(strings.ToLower(host.Pattern), hostname); matched {
+			return host
+		}
+	}
+	return nil
+}
+
+// The first path elements of GitHub pages that aren't repositories, such as https://github.com/sponsors/foo.
+var githubNonOwners = map[string]bool{
+	"about": true, "apps": true, "collections": true, "explore": true, "features": true, "marketplace": true, "orgs": true, "pricing": true,
+	"settings": true, "site": true, "sponsors": true, "topics": true,
+}
+
+// The first path elements of GitLab pages that aren't groups.
+var gitlabNonGroups = map[string]bool{"dashboard": true, "explore": true, "groups": true, "help": true, "users": true}
+
+// Path elements that follow the path of a GitLab project in the URLs of its pages. Newer versions of GitLab separate them from the project
+// with "-" (e.g., /group/project/-/tree/main), but older ones didn't.
+var gitlabRoutes = map[string]bool{
+	"-": true, "archive": true, "blob": true, "branches": true, "commit": true, "commits": true, "compare": true, "issues": true,
+	"merge_requests": true, "pipelines": true, "raw": true, "releases": true, "tags": true, "tree": true, "wikis": true,
+}
+
+// Returns the number of leading path elements that make up the path of a repository on this kind of host, or 0 if they don't.
+func (k HostKind) repoPathLen(elems []string) int {
+	if len(elems) == 0 {
+		return 0
+	}
+	switch k {
+	case HostGitHub, HostBitbucket, HostGitea:
+		if len(elems) < 2 || (k == HostGitHub && githubNonOwners[strings.ToLower(elems[0])]) {
+			return 0
+		}
+		return 2
+	case HostGitLab:
+		if gitlabNonGroups[strings.ToLower(elems[0])] {
+			return 0
+		}
+		n := 0
+		for n < len(elems) && !gitlabRoutes[elems[n]] {
+			n++
+			if strings.HasSuffix(elems[n-1], ".git") {
+				break
+			}
+		}
+		if n < 2 {
+			return 0
+		}
+		return n
+	case HostSourcehut:
+		if len(elems) < 2 || !strings.HasPrefix(elems[0], "~") {
+			return 0
+		}
+		return 2
+	case HostLaunchpad:
+		if strings.HasPrefix(elems[0], "~") {
+			if len(elems) >= 4 && elems[2] == "+git" {
+				return 4
+			} else if len(elems) >= 3 && !strings.HasPrefix(elems[2], "+") {
+				return 3
+			}
+			return 0
+		} else if strings.HasPrefix(elems[0], "+") {
+			return 0
+		}
+		return 1
+	case HostGoogleCode:
+		if len(elems) < 2 || elems[0] != "p" {
+			return 0
+		}
+		return 2
+	}
+	return 0
+}
+
+// A URL that points into a repository, split up.
+type repoLink struct {
+	url   *url.URL
+	vcs   string    // from the prefix of a pip VCS URL ("git", "hg" or "bzr"), if any
+	host  *RepoHost // nil if the repository was recognized from a path element ending in ".git"
+	elems []string  // path elements
+	n     int       // number of path elements that make up the path of the repository
+}
+
+// Matches the "host:path" form that scp and git accept for SSH URLs (e.g., "git@github.com:foo/bar.git"), which also turns up mistakenly with a
+// scheme (e.g., "git://github.com:foo/bar").
+var scpLikeRegexp = regexp.MustCompile(`^(?:([A-Za-z][A-Za-z0-9+.\-]*)://)?((?:[^@/:]+@)?[^@/:]+):([^/0-9:][^:]*)$`)
+
+// Splits up a URL that points into a repository. Returns nil if it doesn't point into one: the repository is recognized from the layout of its
+// host, if the host is one of the given hosts, and otherwise from a path element ending in ".git".
+func parseRepoLink(rawURL string, hosts []*RepoHost) *repoLink {
+	rawURL = strings.TrimSpace(rawURL)
+	vcs := ""
+	for _, prefix := range []string{"git+", "hg+", "bzr+"} {
+		if strings.HasPrefix(rawURL, prefix) {
+			rawURL, vcs = strings.TrimPrefix(rawURL, prefix), strings.TrimSuffix(prefix, "+")
+			break
+		}
+	}
+	if match := scpLikeRegexp.FindStringSubmatch(rawURL); match != nil {
+		scheme := match[1]
+		if scheme == "" {
+			scheme = "ssh"
+		}
+		rawURL = scheme + "://" + match[2] + "/" + match[3]
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	switch u.Scheme {
+	case "http", "https", "git", "ssh":
+	default:
+		return nil
+	}
+	p := u.Path
+	if i := strings.LastIndex(p, "@"); vcs != "" && i >= 0 {
+		p = p[:i]
+	}
+	link := &repoLink{url: u, vcs: vcs, host: findRepoHost(hosts, u.Hostname())}
+	for _, elem := range strings.Split(p, "/") {
+		if elem != "" {
+			link.elems = append(link.elems, elem)
+		}
+	}
+
+	if link.host != nil {
+		link.n = link.host.Kind.repoPathLen(link.elems)
+	} else {
+		for i, elem := range link.elems {
+			if len(elem) > len(".git") && strings.HasSuffix(elem, ".git") {
+				link.n = i + 1
+				break
+			}
+		}
+	}
+	if link.n == 0 {
+		return nil
+	}
+	return link
+}
+
+// Returns the URL of the repository that a URL points into, or "" if it doesn't point into one (see parseRepoLink). The scheme, user name
+// (e.g., the "git@" of an SSH URL, which the server needs) and host are kept. A password is dropped, as is anything after the path of the
+// repository (a path into it, a query or a fragment), the "git+" prefix and "@revision" suffix of pip VCS URLs, and the ".git" suffix on known
+// hosts. ParseRepoRef goes further, and canonicalizes the URL.
+func matchRepoURL(rawURL string, hosts []*RepoHost) string {
+	link := parseRepoLink(rawURL, hosts)
+	if link == nil {
+		return ""
+	}
+	repoPath := strings.Join(link.elems[:link.n], "/")
+	if link.host != nil {
+		repoPath = strings.TrimSuffix(repoPath, ".git")
+	}
+	host := link.url.Host
+	if link.url.User != nil {
+		host = url.User(link.url.User.Username()).String() + "@" + host
+	}
+	return link.url.Scheme + "://" + host + "/" + repoPath
+}
@@ -0,0 +1,111 @@
+package cheerio
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestMatchRepoURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		wantRepoURL string
+	}{
+		{"https://github.com/foo/bar", "https://github.com/foo/bar"},
+		{"http://www.github.com/foo/bar/tree/master", "http://www.github.com/foo/bar"},
+		{"git+https://github.com/foo/bar.git@v1.0#egg=bar", "https://github.com/foo/bar"},
+		{"https://github.com/sponsors/foo", ""},
+		{"https://github.com/foo", ""},
+		{"https://bitbucket.org/foo/bar/src", "https://bitbucket.org/foo/bar"},
+		{"https://gitlab.com/foo/bar", "https://gitlab.com/foo/bar"},
+		{"https://gitlab.com/foo/sub/group/bar/-/tree/main", "https://gitlab.com/foo/sub/group/bar"},
+		{"https://gitlab.com/foo/bar/blob/master/setup.py", "https://gitlab.com/foo/bar"},
+		{"https://gitlab.com/foo/bar.git/", "https://gitlab.com/foo/bar"},
+		{"https://gitlab.com/foo", ""},
+		{"https://gitlab.example.com/foo/bar/issues", "https://gitlab.example.com/foo/bar"},
+		{"https://codeberg.org/foo/bar/src/branch/main", "https://codeberg.org/foo/bar"},
+		{"https://git.sr.ht/~foo/bar/tree", "https://git.sr.ht/~foo/bar"},
+		{"https://git.sr.ht/foo/bar", ""},
+		{"https://launchpad.net/bar", "https://launchpad.net/bar"},
+		{"https://code.launchpad.net/~foo/bar/trunk", "https://code.launchpad.net/~foo/bar/trunk"},
+		{"https://git.launchpad.net/~foo/bar/+git/baz/tree", "https://git.launchpad.net/~foo/bar/+git/baz"},
+		{"https://launchpad.net/+login", ""},
+		{"https://code.google.com/p/bar/source", "https://code.google.com/p/bar"},
+		{"https://git.example.com/scm/foo/bar.git/tree", "https://git.example.com/scm/foo/bar.git"},
+		{"ssh://git@git.example.com:2222/bar.git", "ssh://git@git.example.com:2222/bar.git"},
+		{"ssh://git@github.com:22/foo/bar.git", "ssh://git@github.com:22/foo/bar"},
+		{"git+https://foo@github.com/foo/bar.git@v1.0", "https://foo@github.com/foo/bar"},
+		{"https://git.example.com/foo/bar", ""},
+		{"https://foo.readthedocs.io", ""},
+		{"ftp://github.com/foo/bar", ""},
+		{"not a url", ""},
+	}
+	for _, test := range tests {
+		if repoURL := matchRepoURL(test.url, DefaultRepoHosts); repoURL != test.wantRepoURL {
+			t.Errorf("%s: want %q, got %q", test.url, test.wantRepoURL, repoURL)
+		}
+	}
+
+	// Internal hosts are recognized once configured
+	hosts := append([]*RepoHost{{Pattern: "*.forge.example.com", Kind: HostGitea}}, DefaultRepoHosts...)
+	if repoURL := matchRepoURL("https://code.forge.example.com/foo/bar/issues", hosts); repoURL != "https://code.forge.example.com/foo/bar" {
+		t.Errorf("want the repo on a configured host, got %q", repoURL)
+	}
+	if repoURL := matchRepoURL("https://code.forge.example.com/foo/bar/issues", DefaultRepoHosts); repoURL != "" {
+		t.Errorf("want no repo on an unconfigured host, got %q", repoURL)
+	}
+}
+
+func TestIndexRepoHosts(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "foo", Releases: []*pypitest.Release{{Version: "1.0",
+		PKGInfo: "Metadata-Version: 2.1\nName: foo\nVersion: 1.0\nHome-page: https://code.forge.example.com/foo/foo/issues\n"}}})
+	defer server.Close()
+	hosts := append([]*RepoHost{{Pattern: "*.forge.example.com", Kind: HostGitea}}, DefaultRepoHosts...)
+
+	// Indexes with different hosts can be queried at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			repo, err := (&PackageIndex{URI: server.URL, RepoHosts: hosts}).FetchSourceRepo("foo")
+			if err != nil || repo.WebURL != "https://code.forge.example.com/foo/foo" {
+				t.Errorf("want the repo on the index's host, got %v (%v)", repo, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var noRepo *NoRepoError
+			if _, err := (&PackageIndex{URI: server.URL}).FetchSourceRepoURL("foo"); !errors.As(err, &noRepo) {
+				t.Errorf("want *NoRepoError with the default hosts, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	set := NewIndexSet(&PackageIndex{URI: server.URL})
+	set.RepoHosts = hosts
+	if repoURL, err := set.FetchSourceRepoURL("foo"); err != nil || repoURL != "https://code.forge.example.com/foo/foo" {
+		t.Errorf("want the repo on the set's host, got %q (%v)", repoURL, err)
+	}
+	bundle, err := set.FetchMetadataBundle("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoURL, err := bundle.SourceRepoURL(); err != nil || repoURL != "https://code.forge.example.com/foo/foo" {
+		t.Errorf("want the bundle to use the set's hosts, got %q (%v)", repoURL, err)
+	}
+}
+
+func TestParseHostKind(t *testing.T) {
+	for kind := range hostKindNames {
+		if parsed, err := ParseHostKind(kind.String()); err != nil || parsed != kind {
+			t.Errorf("%s: want %v, got %v (error: %v)", kind, kind, parsed, err)
+		}
+	}
+	if _, err := ParseHostKind("cvs"); err == nil {
+		t.Errorf("want error for an unknown kind")
+	}
+}
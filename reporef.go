@@ -0,0 +1,162 @@
+package cheerio
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// A source repository, in canonical form: its URLs use https, without a ".git" suffix on the web URL, a "www." prefix or a port that isn't for
+// https, and with the host in lower case. The owner and name keep their case, as some hosts treat them case-sensitively.
+type RepoRef struct {
+	Host  string // e.g., "github.com"
+	Owner string // the user or organization; on GitLab, the group and any subgroups (e.g., "foo/bar"). Empty for Launchpad projects.
+	Name  string // on Launchpad, the project, followed by the branch for a Bazaar branch (e.g., "foo/trunk")
+	VCS   string // "git", "hg" or "bzr", or empty if unknown
+
+	CloneURL string // empty if the repository can't be cloned (e.g., from Google Code, which is shut down)
+	WebURL   string
+
+	// The directory within the repository that the package is in, if the URL it was found in points into a directory (e.g.,
+	// https://github.com/foo/bar/tree/main/packages/baz or a pip VCS URL with "#subdirectory=packages/baz"). Empty for the root.
+	Subdir string
+}
+
+func (r *RepoRef) String() string {
+	if r.Subdir != "" {
+		return r.WebURL + " (" + r.Subdir + ")"
+	}
+	return r.WebURL
+}
+
+// Parses a URL that points into a repository (see matchRepoURL and DefaultRepoHosts) into a canonical RepoRef. Besides the URLs of the
+// repository's pages, the clone URLs git and pip accept are understood, including SSH URLs like git@github.com:foo/bar.git.
+func ParseRepoRef(rawURL string) (*RepoRef, error) {
+	return ParseRepoRefWithHosts(rawURL, DefaultRepoHosts)
+}
+
+// Like ParseRepoRef, but recognizes repositories on the given code hosts rather than DefaultRepoHosts.
+func ParseRepoRefWithHosts(rawURL string, hosts []*RepoHost) (*RepoRef, error) {
+	link := parseRepoLink(rawURL, hosts)
+	if link == nil {
+		return nil, fmt.Errorf("Not a repository URL: %s", rawURL)
+	}
+	host := strings.ToLower(link.url.Hostname())
+	if port := link.url.Port(); port != "" && (link.url.Scheme == "http" || link.url.Scheme == "https") {
+		host += ":" + port
+	}
+	elems := append([]string(nil), link.elems[:link.n]...)
+	elems[len(elems)-1] = strings.TrimSuffix(elems[len(elems)-1], ".git")
+	rest := link.elems[link.n:]
+
+	ref := &RepoRef{Host: host, VCS: "git"}
+	kind := HostKind(-1)
+	if link.host != nil {
+		kind = link.host.Kind
+	}
+	switch kind {
+	case HostGitHub, HostGitea:
+		ref.Host = strings.TrimPrefix(host, "www.")
+		ref.Owner, ref.Name = elems[0], elems[1]
+	case HostBitbucket:
+		// Bitbucket hosted Mercurial repositories as well as git ones, under the same URLs, so the VCS is only known from a clone URL
+		ref.Host = strings.TrimPrefix(host, "www.")
+		ref.Owner, ref.Name = elems[0], elems[1]
+		switch user := link.url.User.Username(); {
+		case user == "hg":
+			ref.VCS = "hg"
+		case user != "git" && !strings.HasSuffix(link.elems[link.n-1], ".git"):
+			ref.VCS = ""
+		}
+	case HostSourcehut:
+		ref.Owner, ref.Name = strings.TrimPrefix(elems[0], "~"), elems[1]
+		if strings.HasPrefix(host, "hg.") {
+			ref.VCS = "hg"
+		}
+	case HostLaunchpad:
+		ref.Host = "launchpad.net"
+		switch len(elems) {
+		case 1:
+			ref.Name = elems[0]
+			ref.WebURL = "https://launchpad.net/" + elems[0]
+			ref.CloneURL = "https://git.launchpad.net/" + elems[0]
+		case 3:
+			ref.Owner, ref.Name, ref.VCS = strings.TrimPrefix(elems[0], "~"), elems[1]+"/"+elems[2], "bzr"
+			ref.WebURL = "https://code.launchpad.net/" + strings.Join(elems, "/")
+			ref.CloneURL = "https://bazaar.launchpad.net/" + strings.Join(elems, "/")
+		case 4:
+			ref.Owner, ref.Name = strings.TrimPrefix(elems[0], "~"), elems[3]
+			ref.WebURL = "https://code.launchpad.net/" + strings.Join(elems, "/")
+			ref.CloneURL = "https://git.launchpad.net/" + strings.Join(elems, "/")
+		}
+	case HostGoogleCode:
+		ref.Name, ref.VCS = elems[1], ""
+		ref.WebURL = "https://code.google.com/archive/p/" + elems[1]
+	default: // GitLab, or a repository recognized from its ".git" suffix
+		ref.Owner, ref.Name = strings.Join(elems[:len(elems)-1], "/"), elems[len(elems)-1]
+	}
+	if link.vcs != "" {
+		ref.VCS = link.vcs
+	}
+	if ref.WebURL == "" {
+		ref.WebURL = "https://" + ref.Host + "/" + strings.Join(elems, "/")
+	}
+	if ref.CloneURL == "" && kind != HostGoogleCode {
+		ref.CloneURL = ref.WebURL
+		if ref.VCS == "git" && kind != HostSourcehut {
+			ref.CloneURL += ".git"
+		}
+	}
+	ref.Subdir = repoSubdir(kind, rest, link.url.Fragment)
+	return ref, nil
+}
+
+// Returns the directory within a repository that a URL points into, given the path elements that follow the path of the repository and the
+// URL's fragment, or "" for the root. Only the layouts of the pages that show a directory or file at a given revision are understood.
+func repoSubdir(kind HostKind, rest []string, fragment string) string {
+	// pip VCS URLs give the directory of the package in the fragment
+	if values, err := url.ParseQuery(fragment); err == nil && values.Get("subdirectory") != "" {
+		return cleanSubdir(values.Get("subdirectory"))
+	}
+
+	if kind == HostGitLab && len(rest) > 0 && rest[0] == "-" {
+		rest = rest[1:]
+	}
+	var page string // "tree" for a directory, "blob" for a file, "src" for either
+	var elems []string
+	switch kind {
+	case HostGitHub, HostGitLab:
+		if len(rest) >= 2 && (rest[0] == "tree" || rest[0] == "blob") {
+			page, elems = rest[0], rest[2:]
+		}
+	case HostGitea:
+		// /src/branch/<branch>/<path>, /src/tag/<tag>/<path> or /src/commit/<commit>/<path>
+		if len(rest) >= 3 && rest[0] == "src" {
+			page, elems = "src", rest[3:]
+		}
+	case HostBitbucket:
+		if len(rest) >= 2 && rest[0] == "src" {
+			page, elems = "src", rest[2:]
+		}
+	case HostSourcehut:
+		// /tree/<revision>/item/<path>
+		if len(rest) >= 3 && rest[0] == "tree" && rest[2] == "item" {
+			page, elems = "tree", rest[3:]
+		}
+	}
+	if len(elems) == 0 {
+		return ""
+	}
+	subdir := strings.Join(elems, "/")
+	// For a file, the package is in its directory. Pages that show either are taken to show a file if it has an extension.
+	if page == "blob" || (page == "src" && path.Ext(subdir) != "") {
+		subdir = path.Dir(subdir)
+	}
+	return cleanSubdir(subdir)
+}
+
+// Cleans up a path within a repository, returning "" for the root.
+func cleanSubdir(subdir string) string {
+	return strings.Trim(path.Clean("/"+subdir), "/")
+}
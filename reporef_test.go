@@ -0,0 +1,96 @@
+package cheerio
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
+)
+
+func TestParseRepoRef(t *testing.T) {
+	tests := []struct {
+		url  string
+		want *RepoRef
+	}{
+		{"git://github.com/mitsuhiko/flask", &RepoRef{Host: "github.com", Owner: "mitsuhiko", Name: "flask", VCS: "git",
+			CloneURL: "https://github.com/mitsuhiko/flask.git", WebURL: "https://github.com/mitsuhiko/flask"}},
+		{"git://github.com:gittip/postgres.py", &RepoRef{Host: "github.com", Owner: "gittip", Name: "postgres.py", VCS: "git",
+			CloneURL: "https://github.com/gittip/postgres.py.git", WebURL: "https://github.com/gittip/postgres.py"}},
+		{"git@GitHub.com:Foo/Bar.git", &RepoRef{Host: "github.com", Owner: "Foo", Name: "Bar", VCS: "git",
+			CloneURL: "https://github.com/Foo/Bar.git", WebURL: "https://github.com/Foo/Bar"}},
+		{"http://www.github.com/foo/bar/tree/main/packages/baz", &RepoRef{Host: "github.com", Owner: "foo", Name: "bar", VCS: "git",
+			CloneURL: "https://github.com/foo/bar.git", WebURL: "https://github.com/foo/bar", Subdir: "packages/baz"}},
+		{"https://github.com/foo/bar/blob/main/packages/baz/setup.py", &RepoRef{Host: "github.com", Owner: "foo", Name: "bar", VCS: "git",
+			CloneURL: "https://github.com/foo/bar.git", WebURL: "https://github.com/foo/bar", Subdir: "packages/baz"}},
+		{"git+ssh://git@github.com/foo/bar.git@v1.0#egg=baz&subdirectory=python/", &RepoRef{Host: "github.com", Owner: "foo", Name: "bar",
+			VCS: "git", CloneURL: "https://github.com/foo/bar.git", WebURL: "https://github.com/foo/bar", Subdir: "python"}},
+		{"https://gitlab.com/foo/sub/bar/-/tree/main/src", &RepoRef{Host: "gitlab.com", Owner: "foo/sub", Name: "bar", VCS: "git",
+			CloneURL: "https://gitlab.com/foo/sub/bar.git", WebURL: "https://gitlab.com/foo/sub/bar", Subdir: "src"}},
+		{"ssh://git@gitlab.example.com:2222/foo/bar.git", &RepoRef{Host: "gitlab.example.com", Owner: "foo", Name: "bar", VCS: "git",
+			CloneURL: "https://gitlab.example.com/foo/bar.git", WebURL: "https://gitlab.example.com/foo/bar"}},
+		{"https://codeberg.org/foo/bar/src/branch/main/baz/pyproject.toml", &RepoRef{Host: "codeberg.org", Owner: "foo", Name: "bar",
+			VCS: "git", CloneURL: "https://codeberg.org/foo/bar.git", WebURL: "https://codeberg.org/foo/bar", Subdir: "baz"}},
+		{"https://bitbucket.org/foo/bar/src/default/baz", &RepoRef{Host: "bitbucket.org", Owner: "foo", Name: "bar",
+			CloneURL: "https://bitbucket.org/foo/bar", WebURL: "https://bitbucket.org/foo/bar", Subdir: "baz"}},
+		{"git@bitbucket.org:foo/bar.git", &RepoRef{Host: "bitbucket.org", Owner: "foo", Name: "bar", VCS: "git",
+			CloneURL: "https://bitbucket.org/foo/bar.git", WebURL: "https://bitbucket.org/foo/bar"}},
+		{"ssh://hg@bitbucket.org/foo/bar", &RepoRef{Host: "bitbucket.org", Owner: "foo", Name: "bar", VCS: "hg",
+			CloneURL: "https://bitbucket.org/foo/bar", WebURL: "https://bitbucket.org/foo/bar"}},
+		{"hg+https://bitbucket.org/foo/bar", &RepoRef{Host: "bitbucket.org", Owner: "foo", Name: "bar", VCS: "hg",
+			CloneURL: "https://bitbucket.org/foo/bar", WebURL: "https://bitbucket.org/foo/bar"}},
+		{"https://hg.sr.ht/~foo/bar/browse", &RepoRef{Host: "hg.sr.ht", Owner: "foo", Name: "bar", VCS: "hg",
+			CloneURL: "https://hg.sr.ht/~foo/bar", WebURL: "https://hg.sr.ht/~foo/bar"}},
+		{"https://git.sr.ht/~foo/bar/tree/main/item/baz", &RepoRef{Host: "git.sr.ht", Owner: "foo", Name: "bar", VCS: "git",
+			CloneURL: "https://git.sr.ht/~foo/bar", WebURL: "https://git.sr.ht/~foo/bar", Subdir: "baz"}},
+		{"https://launchpad.net/bar", &RepoRef{Host: "launchpad.net", Name: "bar", VCS: "git",
+			CloneURL: "https://git.launchpad.net/bar", WebURL: "https://launchpad.net/bar"}},
+		{"https://code.launchpad.net/~foo/bar/trunk", &RepoRef{Host: "launchpad.net", Owner: "foo", Name: "bar/trunk", VCS: "bzr",
+			CloneURL: "https://bazaar.launchpad.net/~foo/bar/trunk", WebURL: "https://code.launchpad.net/~foo/bar/trunk"}},
+		{"https://code.google.com/p/mock", &RepoRef{Host: "code.google.com", Name: "mock",
+			WebURL: "https://code.google.com/archive/p/mock"}},
+		{"http://git.example.com:8080/scm/foo/bar.git", &RepoRef{Host: "git.example.com:8080", Owner: "scm/foo", Name: "bar", VCS: "git",
+			CloneURL: "https://git.example.com:8080/scm/foo/bar.git", WebURL: "https://git.example.com:8080/scm/foo/bar"}},
+	}
+	for _, test := range tests {
+		ref, err := ParseRepoRef(test.url)
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		} else if !reflect.DeepEqual(ref, test.want) {
+			t.Errorf("%s: want %+v, got %+v", test.url, test.want, ref)
+		}
+	}
+
+	if _, err := ParseRepoRef("https://foo.readthedocs.io"); err == nil {
+		t.Errorf("want error for a URL that isn't a repository's")
+	}
+}
+
+func TestFetchSourceRepo(t *testing.T) {
+	server := pypitest.NewServer(&pypitest.Project{Name: "baz", Releases: []*pypitest.Release{{
+		Version: "1.0",
+		PKGInfo: "Metadata-Version: 1.2\nName: baz\nVersion: 1.0\nProject-URL: Source, https://github.com/foo/bar/tree/main/baz\n",
+	}}})
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	ref, err := index.FetchSourceRepo("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.WebURL != "https://github.com/foo/bar" || ref.Subdir != "baz" {
+		t.Errorf("want https://github.com/foo/bar (baz), got %s", ref)
+	}
+	// The string result stays as it was
+	if repoURL, err := index.FetchSourceRepoURL("baz"); err != nil || repoURL != "https://github.com/foo/bar" {
+		t.Errorf("want https://github.com/foo/bar, got %q (error: %v)", repoURL, err)
+	}
+
+	// Hardcoded URLs are canonicalized too
+	ref, err = index.FetchSourceRepo("flask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.CloneURL != "https://github.com/mitsuhiko/flask.git" {
+		t.Errorf("want https://github.com/mitsuhiko/flask.git, got %s", ref.CloneURL)
+	}
+}
@@ -0,0 +1,348 @@
+package cheerio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// Serves a directory of distribution files (sdists, eggs, wheels) as a simple index. Project pages are served as PEP 503 HTML, or as PEP 691
+// JSON to clients that ask for it, with sha256 hashes and the Requires-Python of each file. Files are found anywhere under the directory, so
+// both flat directories and mirrors written by Mirror can be served. Files are served at /packages/<path relative to the directory>.
+type IndexServer struct {
+	Dir string
+
+	// How long a listing of the directory is used before it is listed again, so that files added or removed show up. If zero,
+	// DefaultRefreshInterval is used; if negative, the directory is listed for every request.
+	RefreshInterval time.Duration
+
+	mu    sync.Mutex
+	infos map[string]*servedFileInfo // keyed by path
+
+	scanMu  sync.Mutex
+	scanned time.Time
+	files   []*servedFile
+	byPath  map[string]*servedFile // keyed by relPath
+}
+
+const DefaultRefreshInterval = 10 * time.Second
+
+func NewIndexServer(dir string) *IndexServer {
+	return &IndexServer{Dir: dir, infos: make(map[string]*servedFileInfo)}
+}
+
+// Information about a served file, computed once per version of the file.
+type servedFileInfo struct {
+	size           int64
+	modTime        time.Time
+	sha256         string
+	requiresPython string
+}
+
+// A distribution file found in the served directory.
+type servedFile struct {
+	project string
+	relPath string // slash-separated, relative to the served directory
+	info    os.FileInfo
+}
+
+const (
+	simpleJSONLatestContentType = "application/vnd.pypi.simple.latest+json"
+	simpleHTMLLatestContentType = "application/vnd.pypi.simple.latest+html"
+)
+
+func (s *IndexServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	files, byPath, err := s.servedFiles()
+	if err != nil {
+		http.Error(w, "error reading distribution directory", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == "/simple":
+		http.Redirect(w, r, "/simple/", http.StatusMovedPermanently)
+	case r.URL.Path == "/simple/":
+		s.serveRoot(w, r, files)
+	case strings.HasPrefix(r.URL.Path, "/simple/"):
+		project := strings.TrimPrefix(r.URL.Path, "/simple/")
+		if canonical := canonicalName(strings.TrimSuffix(project, "/")); project != canonical+"/" {
+			http.Redirect(w, r, "/simple/"+canonical+"/", http.StatusMovedPermanently)
+			return
+		}
+		s.serveProject(w, r, strings.TrimSuffix(project, "/"), files)
+	case strings.HasPrefix(r.URL.Path, "/packages/"):
+		// Only listed files are served, so the path can't escape the directory
+		relPath := strings.TrimPrefix(r.URL.Path, "/packages/")
+		if _, in := byPath[relPath]; !in {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(s.Dir, filepath.FromSlash(relPath)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *IndexServer) serveRoot(w http.ResponseWriter, r *http.Request, files []*servedFile) {
+	var projects []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !seen[file.project] {
+			seen[file.project] = true
+			projects = append(projects, file.project)
+		}
+	}
+	sort.Strings(projects)
+
+	contentType := negotiateSimpleContentType(r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	if contentType == simpleJSONContentType {
+		type project struct {
+			Name string `json:"name"`
+		}
+		root := struct {
+			Meta     map[string]string `json:"meta"`
+			Projects []project         `json:"projects"`
+		}{Meta: map[string]string{"api-version": "1.1"}, Projects: []project{}}
+		for _, name := range projects {
+			root.Projects = append(root.Projects, project{Name: name})
+		}
+		json.NewEncoder(w).Encode(root)
+		return
+	}
+	writeSimpleRootHTML(w, projects)
+}
+
+func (s *IndexServer) serveProject(w http.ResponseWriter, r *http.Request, project string, files []*servedFile) {
+	var distFiles []*DistFile
+	var sizes []int64
+	for _, file := range files {
+		if file.project != project {
+			continue
+		}
+		info, err := s.fileInfo(file)
+		if err != nil {
+			http.Error(w, "error reading "+path.Base(file.relPath), http.StatusInternalServerError)
+			return
+		}
+		distFiles = append(distFiles, &DistFile{
+			Filename:       path.Base(file.relPath),
+			URL:            "/packages/" + escapePath(file.relPath),
+			Version:        versionFromFilename(project, path.Base(file.relPath)),
+			Hashes:         fetch.Hashes{"sha256": info.sha256},
+			RequiresPython: info.requiresPython,
+			UploadTime:     info.modTime,
+		})
+		sizes = append(sizes, info.size)
+	}
+	if len(distFiles) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := negotiateSimpleContentType(r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	if contentType == simpleJSONContentType {
+		json.NewEncoder(w).Encode(simpleJSONProject(project, distFiles, sizes))
+		return
+	}
+	writeSimpleHTML(w, project, distFiles, func(file *DistFile) string { return file.URL })
+}
+
+// Builds the PEP 691 (API version 1.1) representation of a project page.
+func simpleJSONProject(project string, files []*DistFile, sizes []int64) interface{} {
+	type jsonFile struct {
+		Filename       string            `json:"filename"`
+		URL            string            `json:"url"`
+		Hashes         map[string]string `json:"hashes"`
+		RequiresPython string            `json:"requires-python,omitempty"`
+		Yanked         bool              `json:"yanked"`
+		Size           int64             `json:"size"`
+		UploadTime     string            `json:"upload-time,omitempty"`
+	}
+	page := struct {
+		Meta     map[string]string `json:"meta"`
+		Name     string            `json:"name"`
+		Versions []string          `json:"versions"`
+		Files    []jsonFile        `json:"files"`
+	}{Meta: map[string]string{"api-version": "1.1"}, Name: project, Versions: []string{}}
+
+	for _, release := range groupReleases(append([]*DistFile(nil), files...)) {
+		page.Versions = append(page.Versions, release.Version)
+	}
+	for i, file := range files {
+		page.Files = append(page.Files, jsonFile{
+			Filename:       file.Filename,
+			URL:            file.URL,
+			Hashes:         file.Hashes,
+			RequiresPython: file.RequiresPython,
+			Size:           sizes[i],
+			UploadTime:     file.UploadTime.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		})
+	}
+	return page
+}
+
+// Chooses between the PEP 691 JSON and PEP 503 HTML forms, based on the format query parameter or the Accept header. Defaults to HTML.
+func negotiateSimpleContentType(r *http.Request) string {
+	accept := r.URL.Query().Get("format")
+	if accept == "" {
+		accept = r.Header.Get("Accept")
+	}
+
+	var bestType string
+	bestQ := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		var contentType string
+		switch mediaType {
+		case simpleJSONContentType, simpleJSONLatestContentType:
+			contentType = simpleJSONContentType
+		case simpleHTMLContentType, simpleHTMLLatestContentType, "text/html", "*/*":
+			contentType = simpleHTMLContentType
+		default:
+			continue
+		}
+		if q > bestQ {
+			bestType, bestQ = contentType, q
+		}
+	}
+	if bestType == "" || bestQ <= 0 {
+		return simpleHTMLContentType
+	}
+	return bestType
+}
+
+// Returns the distribution files under the served directory, listing it again if the last listing is older than the refresh interval.
+func (s *IndexServer) servedFiles() ([]*servedFile, map[string]*servedFile, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	interval := s.RefreshInterval
+	if interval == 0 {
+		interval = DefaultRefreshInterval
+	}
+	if !s.scanned.IsZero() && time.Since(s.scanned) < interval {
+		return s.files, s.byPath, nil
+	}
+
+	files, err := s.scan()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.files, s.byPath, s.scanned = files, make(map[string]*servedFile, len(files)), time.Now()
+	for _, file := range files {
+		s.byPath[file.relPath] = file
+	}
+	return s.files, s.byPath, nil
+}
+
+// Finds the distribution files under the served directory, skipping hidden files and any simple/ tree.
+func (s *IndexServer) scan() ([]*servedFile, error) {
+	var files []*servedFile
+	err := filepath.Walk(s.Dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && filePath != s.Dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if filePath == filepath.Join(s.Dir, "simple") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		project := projectFromFilename(info.Name(), "")
+		if project == "" {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.Dir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, &servedFile{project: canonicalName(project), relPath: filepath.ToSlash(relPath), info: info})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].relPath < files[j].relPath })
+	return files, err
+}
+
+var requiresPythonRegexp = regexp.MustCompile(`(?m)^Requires-Python:[ \t]*(.*?)\s*$`)
+var wheelMetadataPattern = regexp.MustCompile(`^[^/]+\.dist-info/METADATA$`)
+
+// Returns the hash and Requires-Python of a file, computing them if the file is new or has changed.
+func (s *IndexServer) fileInfo(file *servedFile) (*servedFileInfo, error) {
+	filePath := filepath.Join(s.Dir, filepath.FromSlash(file.relPath))
+	s.mu.Lock()
+	info, in := s.infos[filePath]
+	s.mu.Unlock()
+	if in && info.size == file.info.Size() && info.modTime.Equal(file.info.ModTime()) {
+		return info, nil
+	}
+
+	digest, err := sha256File(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info = &servedFileInfo{size: file.info.Size(), modTime: file.info.ModTime(), sha256: digest}
+
+	// Archives without metadata (or that can't be read) are still served, just without Requires-Python
+	var metadata []byte
+	switch name := file.info.Name(); {
+	case strings.HasSuffix(name, ".whl"):
+		metadata, _ = fetch.DefaultClient.DecompressFile(context.Background(), filePath, wheelMetadataPattern)
+	case tarRegexp.MatchString(name) || strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".egg"):
+		metadata, _ = fetch.DefaultClient.DecompressFile(context.Background(), filePath, pkgInfoPattern)
+	}
+	if match := requiresPythonRegexp.FindSubmatch(metadata); match != nil {
+		info.requiresPython = string(match[1])
+	}
+
+	s.mu.Lock()
+	if s.infos == nil {
+		s.infos = make(map[string]*servedFileInfo)
+	}
+	s.infos[filePath] = info
+	s.mu.Unlock()
+	return info, nil
+}
+
+// Escapes each segment of a slash-separated path for use in a URL.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
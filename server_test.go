@@ -0,0 +1,127 @@
+package cheerio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexServer(t *testing.T) {
+	server := httptest.NewServer(NewIndexServer("testdata/findlinks"))
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
+	// PackageIndex asks for JSON, which includes upload times
+	releases, err := index.Releases("Foo_Bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[1].Version != "1.0" {
+		t.Fatalf("want releases 0.9 and 1.0, got %+v", releases)
+	}
+	if releases[1].UploadTime().IsZero() {
+		t.Errorf("want upload time from JSON page")
+	}
+	if rp := releases[1].RequiresPython(); rp != ">=2.6" {
+		t.Errorf("want requires-python >=2.6, got %q", rp)
+	}
+
+	// Downloads are checked against the served hashes
+	reqs, err := index.FetchPackageRequirements("foo-bar")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reqs) != 2 {
+		t.Errorf("want 2 requirements, got %+v", reqs)
+	}
+
+	pkgs, err := index.AllPackages()
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []string{"foo-bar", "other"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("want packages %v, got %v", want, pkgs)
+	}
+
+	// Plain HTML clients, like pip, get a PEP 503 page
+	resp, err := http.Get(server.URL + "/simple/foo-bar/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `href="/packages/foo-bar-1.0.tar.gz#sha256=`) || !strings.Contains(string(body), `data-requires-python="&gt;=2.6"`) {
+		t.Errorf("unexpected project page:\n%s", body)
+	}
+
+	resp, err = http.Get(server.URL + "/packages/../local_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("want 404 for a path outside the directory, got %s", resp.Status)
+	}
+}
+
+func TestIndexServerRefresh(t *testing.T) {
+	dir := t.TempDir()
+	copyFile := func(name string) {
+		b, err := ioutil.ReadFile(filepath.Join("testdata/findlinks", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	projects := func(server *httptest.Server) []string {
+		req, _ := http.NewRequest("GET", server.URL+"/simple/", nil)
+		req.Header.Set("Accept", simpleJSONContentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var root struct {
+			Meta     map[string]string `json:"meta"`
+			Projects []struct {
+				Name string `json:"name"`
+			} `json:"projects"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&root); err != nil {
+			t.Fatal(err)
+		}
+		if root.Meta["api-version"] != "1.1" {
+			t.Errorf("want API version 1.1, got %q", root.Meta["api-version"])
+		}
+		var names []string
+		for _, project := range root.Projects {
+			names = append(names, project.Name)
+		}
+		return names
+	}
+
+	copyFile("foo-bar-1.0.tar.gz")
+	cached := &IndexServer{Dir: dir, RefreshInterval: time.Hour}
+	cachedServer := httptest.NewServer(cached)
+	defer cachedServer.Close()
+	uncachedServer := httptest.NewServer(&IndexServer{Dir: dir, RefreshInterval: -1})
+	defer uncachedServer.Close()
+	if got := projects(cachedServer); !reflect.DeepEqual(got, []string{"foo-bar"}) {
+		t.Errorf("want [foo-bar], got %v", got)
+	}
+
+	// The directory isn't listed again until the listing is older than the refresh interval
+	copyFile("other-2.0.tar.gz")
+	if got := projects(cachedServer); !reflect.DeepEqual(got, []string{"foo-bar"}) {
+		t.Errorf("want the cached listing [foo-bar], got %v", got)
+	}
+	if got := projects(uncachedServer); !reflect.DeepEqual(got, []string{"foo-bar", "other"}) {
+		t.Errorf("want [foo-bar other], got %v", got)
+	}
+}
@@ -0,0 +1,258 @@
+package cheerio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+// A distribution file (sdist, egg, wheel, ...) listed on a project's simple index page.
+type DistFile struct {
+	Filename       string
+	URL            string
+	Version        string
+	Hashes         fetch.Hashes  // digests published by the index, from the URL fragment or the JSON "hashes" field
+	RequiresPython string        // value of data-requires-python, if any
+	Yanked         bool          // PEP 592 yanked status
+	YankedReason   string        // optional reason given for the yank
+	UploadTime     time.Time     // zero if the index does not report upload times
+	Index          *PackageIndex // the index that listed the file
+}
+
+// Content types for the PEP 691 JSON and PEP 503 HTML forms of the simple API.
+const (
+	simpleJSONContentType = "application/vnd.pypi.simple.v1+json"
+	simpleHTMLContentType = "application/vnd.pypi.simple.v1+html"
+)
+
+var simpleAcceptHeader = fmt.Sprintf("%s, %s;q=0.2, text/html;q=0.1", simpleJSONContentType, simpleHTMLContentType)
+
+var anchorRegexp = regexp.MustCompile(`(?is)<a\s+([^>]*)>(.*?)</a>`)
+var attrRegexp = regexp.MustCompile(`([A-Za-z][A-Za-z0-9\-]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+
+// Fetches and parses the simple index page of a package. The JSON form (PEP 691) is requested first, because it is the only one that includes
+// upload times; servers that don't support it fall back to the HTML form (PEP 503). Returns no files (and no error) if the package does not exist.
+// Concurrent calls for the same package share one fetch.
+func (p *PackageIndex) distFiles(ctx context.Context, pkg string) ([]*DistFile, error) {
+	files, err := p.flights.do(ctx, "files "+canonicalName(pkg), func(ctx context.Context) (interface{}, error) {
+		return p.fetchDistFiles(ctx, pkg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Each caller gets its own slice, because callers sort it
+	return append([]*DistFile(nil), files.([]*DistFile)...), nil
+}
+
+func (p *PackageIndex) fetchDistFiles(ctx context.Context, pkg string) ([]*DistFile, error) {
+	if dir, isFlat := p.flatDir(); isFlat {
+		return p.flatDirFiles(dir, pkg)
+	}
+
+	pg, err := p.fetcher().FetchPage(ctx, fmt.Sprintf("%s/simple/%s/", p.baseURL(), canonicalName(pkg)), simpleAcceptHeader, nil)
+	if statusErr, ok := err.(*fetch.StatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pageURL, err := url.Parse(pg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*DistFile
+	if strings.HasPrefix(pg.ContentType, simpleJSONContentType) {
+		files, err = parseSimpleJSON(pageURL, pg.Body)
+	} else {
+		files, err = parseSimpleHTML(pageURL, pg.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		file.Version = versionFromFilename(pkg, file.Filename)
+		file.Index = p
+	}
+	return files, nil
+}
+
+// Parses the anchors of a PEP 503 project page.
+func parseSimpleHTML(base *url.URL, body []byte) ([]*DistFile, error) {
+	var files []*DistFile
+	for _, match := range anchorRegexp.FindAllSubmatch(body, -1) {
+		attrs := parseAttrs(string(match[1]))
+		href, in := attrs["href"]
+		if !in {
+			continue
+		}
+		fileURL, err := base.Parse(href)
+		if err != nil {
+			return nil, fmt.Errorf("Bad link %q: %s", href, err)
+		} else if !allowedLink(base, fileURL) {
+			continue
+		}
+		file := &DistFile{
+			Filename:       strings.TrimSpace(html.UnescapeString(string(match[2]))),
+			RequiresPython: attrs["data-requires-python"],
+		}
+		if file.Filename == "" {
+			file.Filename = path.Base(fileURL.Path)
+		}
+		if reason, in := attrs["data-yanked"]; in {
+			file.Yanked = true
+			file.YankedReason = reason
+		}
+		file.Hashes = hashesFromFragment(fileURL.Fragment)
+		fileURL.Fragment = ""
+		file.URL = fileURL.String()
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Reports whether a page may link to a file: links are to HTTP(S) URLs, or to file:// URLs from a page that is itself local. Other links are left
+// out, so that a remote index can't have local files read.
+func allowedLink(page, link *url.URL) bool {
+	switch link.Scheme {
+	case "http", "https":
+		return true
+	case "file":
+		return page.Scheme == "file"
+	}
+	return false
+}
+
+// Parses hashes from a link fragment of the form "<algorithm>=<hex digest>", e.g., "sha256=5b3c...". Other parts of the fragment, such as
+// "egg=foo", are ignored. Returns nil if there are none.
+func hashesFromFragment(fragment string) fetch.Hashes {
+	var hashes fetch.Hashes
+	for _, part := range strings.Split(fragment, "&") {
+		if eq := strings.Index(part, "="); eq > 0 && eq < len(part)-1 && fetch.SupportedHash(part[:eq]) {
+			if hashes == nil {
+				hashes = make(fetch.Hashes)
+			}
+			hashes[strings.ToLower(part[:eq])] = strings.ToLower(part[eq+1:])
+		}
+	}
+	return hashes
+}
+
+// Parses the HTML attributes of a tag into a map. Attributes without a value (e.g., a bare data-yanked) map to the empty string.
+func parseAttrs(rawAttrs string) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range attrRegexp.FindAllStringSubmatch(rawAttrs, -1) {
+		attrs[strings.ToLower(match[1])] = html.UnescapeString(match[2] + match[3] + match[4])
+	}
+	return attrs
+}
+
+type simpleJSONPage struct {
+	Files []struct {
+		Filename       string          `json:"filename"`
+		URL            string          `json:"url"`
+		Hashes         fetch.Hashes    `json:"hashes"`
+		RequiresPython string          `json:"requires-python"`
+		Yanked         json.RawMessage `json:"yanked"`
+		UploadTime     string          `json:"upload-time"`
+	} `json:"files"`
+}
+
+// Parses a PEP 691 project page.
+func parseSimpleJSON(base *url.URL, body []byte) ([]*DistFile, error) {
+	var page simpleJSONPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, err
+	}
+	files := make([]*DistFile, 0, len(page.Files))
+	for _, f := range page.Files {
+		fileURL, err := base.Parse(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("Bad link %q: %s", f.URL, err)
+		} else if !allowedLink(base, fileURL) {
+			continue
+		}
+		file := &DistFile{
+			Filename:       f.Filename,
+			Hashes:         f.Hashes,
+			RequiresPython: f.RequiresPython,
+		}
+		if len(file.Hashes) == 0 {
+			file.Hashes = hashesFromFragment(fileURL.Fragment)
+		}
+		fileURL.Fragment = ""
+		file.URL = fileURL.String()
+
+		// "yanked" is either a boolean or a string giving the reason
+		var reason string
+		if err := json.Unmarshal(f.Yanked, &file.Yanked); err != nil && json.Unmarshal(f.Yanked, &reason) == nil {
+			file.Yanked = true
+			file.YankedReason = reason
+		}
+
+		if f.UploadTime != "" {
+			if t, err := time.Parse(time.RFC3339Nano, f.UploadTime); err == nil {
+				file.UploadTime = t
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Writes a PEP 503 project page linking to the given files. The href function returns the link to each file, without the hash fragment.
+func writeSimpleHTML(w io.Writer, project string, files []*DistFile, href func(*DistFile) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta name=\"pypi:repository-version\" content=\"1.0\">\n<title>Links for %s</title>\n</head>\n",
+		html.EscapeString(project))
+	fmt.Fprintf(&buf, "<body>\n<h1>Links for %s</h1>\n", html.EscapeString(project))
+	for _, file := range files {
+		link := href(file)
+		if algorithm, digest := strongestHash(file.Hashes); algorithm != "" {
+			link += "#" + algorithm + "=" + digest
+		}
+		fmt.Fprintf(&buf, "<a href=\"%s\"", html.EscapeString(link))
+		if file.RequiresPython != "" {
+			fmt.Fprintf(&buf, " data-requires-python=\"%s\"", html.EscapeString(file.RequiresPython))
+		}
+		if file.Yanked {
+			fmt.Fprintf(&buf, " data-yanked=\"%s\"", html.EscapeString(file.YankedReason))
+		}
+		fmt.Fprintf(&buf, ">%s</a><br/>\n", html.EscapeString(file.Filename))
+	}
+	buf.WriteString("</body>\n</html>\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Writes the PEP 503 root page listing the given projects, each of which links to "<project>/".
+func writeSimpleRootHTML(w io.Writer, projects []string) error {
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta name=\"pypi:repository-version\" content=\"1.0\">\n<title>Simple index</title>\n</head>\n<body>\n")
+	for _, project := range projects {
+		fmt.Fprintf(&buf, "<a href=\"%s/\">%s</a><br/>\n", html.EscapeString(canonicalName(project)), html.EscapeString(project))
+	}
+	buf.WriteString("</body>\n</html>\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Returns the strongest of the hashes that PEP 503 allows in links, preferring sha256 as PyPI does.
+func strongestHash(hashes fetch.Hashes) (algorithm, digest string) {
+	for _, algorithm := range []string{"sha256", "sha512", "sha384", "sha224", "sha1", "md5"} {
+		if digest, in := hashes[algorithm]; in && digest != "" {
+			return algorithm, digest
+		}
+	}
+	return "", ""
+}
@@ -1,51 +1,312 @@
 package cheerio
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"regexp"
+	"sort"
+	"strings"
 )
 
-var homepageRegexp = regexp.MustCompile(`Home-page: (.+)\n`)
-var repoPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`Home-page: (https?://github.com/(:?[^/\n\r]+)/(:?[^/\n\r]+))(:?/.*)?\s`),
-	regexp.MustCompile(`Home-page: (https?://bitbucket.org/(:?[^/\n\r]+)/(:?[^/\n\r]+))(:?/.*)?\s`),
-	regexp.MustCompile(`Home-page: (https?://code.google.com/p/(:?[^/\n\r]+))(:?/.*)?\s`),
-}
+// Labels of Project-URLs that link to the source code, in order of preference, normalized as Metadata.ProjectURL does.
+var sourceURLLabels = []string{"source", "sourcecode", "repository", "repo", "code", "github"}
+
+// URLs in free text, such as a description in reStructuredText or Markdown.
+var textURLRegexp = regexp.MustCompile("(?:(?:git|hg|bzr)\\+)?(?:https?|git|ssh)://[^\\s<>\"'`()\\[\\]{}]+")
 
 var pkgInfoPattern = regexp.MustCompile(`(?:[^/]+/)*PKG\-INFO`)
 
-// Returns the source repository URL for a given PyPI package. This information is not explicitly specified anywhere in PyPI metadata, so try to infer
-// it by doing the following: First, check if it is hardcoded below. If not, then fetch the metadata from the PyPI server and check if the website
-// (specified in the metdata) pattern matches a repository URL.
+// Where a link to a candidate source repository was found.
+type EvidenceKind int
+
+const (
+	SourceURLEvidence   EvidenceKind = iota // a Project-URL labeled as the source code (e.g., "Source", "Repository")
+	HomePageEvidence                        // the Home-page
+	DownloadURLEvidence                     // the Download-URL
+	ProjectURLEvidence                      // any other Project-URL (e.g., "Bug Tracker, https://github.com/foo/bar/issues")
+	HardcodedEvidence                       // the repositories of well-known packages hardcoded below, for those whose metadata doesn't say
+	DescriptionEvidence                     // a link in the description
+)
+
+var evidenceKindNames = map[EvidenceKind]string{
+	SourceURLEvidence:   "source-url",
+	HomePageEvidence:    "home-page",
+	DownloadURLEvidence: "download-url",
+	ProjectURLEvidence:  "project-url",
+	HardcodedEvidence:   "hardcoded",
+	DescriptionEvidence: "description",
+}
+
+func (k EvidenceKind) String() string {
+	if name, in := evidenceKindNames[k]; in {
+		return name
+	}
+	return fmt.Sprintf("EvidenceKind(%d)", int(k))
+}
+
+func (k EvidenceKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// How likely a link of each kind is to point into the package's own repository. A link in the description is as likely as 0.5 if the repository
+// is named after the package, and half as likely otherwise, as descriptions often link to related projects.
+var evidenceConfidence = map[EvidenceKind]float64{
+	SourceURLEvidence:   0.95,
+	HomePageEvidence:    0.85,
+	DownloadURLEvidence: 0.75,
+	ProjectURLEvidence:  0.6,
+	HardcodedEvidence:   0.55,
+	DescriptionEvidence: 0.5,
+}
+
+// A link that points into a candidate source repository, and where it was found.
+type RepoEvidence struct {
+	Kind       EvidenceKind
+	Label      string  // the label of a Project-URL
+	URL        string  // the link, as it appears in the metadata or the hardcoded table
+	Confidence float64 // how likely the link is to point into the package's repository, from 0 to 1
+}
+
+// A repository that may be the source repository of a package, with the evidence for it.
+type RepoCandidate struct {
+	Repo *RepoRef
+
+	// How likely the repository is to be the package's, from 0 to 1. Links of different kinds that agree on the repository add up to more
+	// confidence than either of them alone, but many links of the same kind (e.g., badges in the description) count only once.
+	Confidence float64
+
+	Evidence []*RepoEvidence // the strongest first
+}
+
+// Returned when a package's metadata doesn't link to its source repository, and none is hardcoded for it.
+type NoRepoError struct {
+	Package  string
+	HomePage string // the package's Home-page, if any
+}
+
+func (e *NoRepoError) Error() string {
+	if e.HomePage != "" {
+		return fmt.Sprintf("Could not parse repo URL from homepage: %s", e.HomePage)
+	}
+	return fmt.Sprintf("No repo URL found in metadata of %s", e.Package)
+}
+
+// Returns the source repository URL for a given PyPI package. This information is not explicitly specified anywhere in PyPI metadata, so it is
+// inferred from the package's metadata: of the repositories its links point into (see matchRepoURL and the index's RepoHosts), the candidate with the
+// highest confidence is used (see FetchSourceRepoCandidates). Unless other links agree on another repository, that is the first of:
+//
+//  1. A Project-URL labeled as the source code ("Source", "Source Code", "Repository", "Repo", "Code" or "GitHub", in that order)
+//  2. The Home-page
+//  3. The Download-URL
+//  4. Any other Project-URL (e.g., "Bug Tracker, https://github.com/foo/bar/issues"), in the order they are listed
+//  5. The URL hardcoded below for the package, which is also used if the metadata can't be fetched
+//  6. A link in the description, preferring a repository named after the package to the first one
+//
+// If there is none, a *NoRepoError is returned.
 func (p *PackageIndex) FetchSourceRepoURL(pkg string) (string, error) {
-	b, err := p.FetchRawMetadata(pkg, pkgInfoPattern, pkgInfoPattern, pkgInfoPattern)
+	return p.FetchSourceRepoURLContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchSourceRepoURLContext(ctx context.Context, pkg string) (string, error) {
+	return fetchSourceRepoURL(ctx, p, p.repoHosts(), pkg)
+}
+
+func fetchSourceRepoURL(ctx context.Context, idx Index, hosts []*RepoHost, pkg string) (string, error) {
+	candidates, homePage, err := fetchSourceRepoCandidates(ctx, idx, hosts, pkg)
+	if err != nil {
+		return "", err
+	}
+	return bestRepoURL(pkg, homePage, candidates, hosts)
+}
+
+// Like FetchSourceRepoURL, but returns the repository in canonical form, and with the directory of the package if it is in a subdirectory
+// (e.g., when the metadata links to https://github.com/foo/bar/tree/main/packages/baz).
+func (p *PackageIndex) FetchSourceRepo(pkg string) (*RepoRef, error) {
+	return p.FetchSourceRepoContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchSourceRepoContext(ctx context.Context, pkg string) (*RepoRef, error) {
+	return fetchSourceRepo(ctx, p, p.repoHosts(), pkg)
+}
+
+func fetchSourceRepo(ctx context.Context, idx Index, hosts []*RepoHost, pkg string) (*RepoRef, error) {
+	candidates, homePage, err := fetchSourceRepoCandidates(ctx, idx, hosts, pkg)
+	if err != nil {
+		return nil, err
+	}
+	return bestRepo(pkg, homePage, candidates)
+}
+
+// Returns every repository that the metadata of a package links to, or that is hardcoded for it, ranked by how likely it is to be the package's
+// source repository, with the links that point into it. Unlike FetchSourceRepoURL, it isn't an error if there is none. This lets a caller
+// accept the best candidate only if it is confident enough (e.g., backed by a Project-URL labeled as the source code, or by several kinds of
+// links), and have a person review the others.
+func (p *PackageIndex) FetchSourceRepoCandidates(pkg string) ([]*RepoCandidate, error) {
+	return p.FetchSourceRepoCandidatesContext(context.Background(), pkg)
+}
+
+func (p *PackageIndex) FetchSourceRepoCandidatesContext(ctx context.Context, pkg string) ([]*RepoCandidate, error) {
+	candidates, _, err := fetchSourceRepoCandidates(ctx, p, p.repoHosts(), pkg)
+	return candidates, err
+}
+
+// Fetches the metadata of a package and returns its candidate source repositories, and its Home-page for the error if there is none. If the
+// metadata can't be fetched, the hardcoded repository is the only candidate.
+func fetchSourceRepoCandidates(ctx context.Context, idx Index, hosts []*RepoHost, pkg string) (candidates []*RepoCandidate, homePage string, err error) {
+	files, err := idx.FetchMetadataFilesContext(ctx, pkg, pkgInfoPattern, pkgInfoPattern, pkgInfoPattern)
 	if err != nil {
 		// Try to fall back to hard-coded URLs
-		if hardURL, in := pypiRepos[NormalizedPkgName(pkg)]; in {
-			return hardURL, nil
-		} else {
-			return "", err
+		if candidates := rankRepoCandidates(hardcodedRepoEvidence(pkg), hosts); len(candidates) > 0 {
+			return candidates, "", nil
+		}
+		return nil, "", err
+	}
+	return sourceRepoCandidates(pkg, topLevelFile(pkg, files), hosts)
+}
+
+// Infers the source repository URL of a package from its PKG-INFO.
+func sourceRepoURL(pkg string, pkgInfo []byte, hosts []*RepoHost) (string, error) {
+	candidates, homePage, err := sourceRepoCandidates(pkg, pkgInfo, hosts)
+	if err != nil {
+		return "", err
+	}
+	return bestRepoURL(pkg, homePage, candidates, hosts)
+}
+
+// Infers the source repository of a package from its PKG-INFO, as a RepoRef.
+func sourceRepo(pkg string, pkgInfo []byte, hosts []*RepoHost) (*RepoRef, error) {
+	candidates, homePage, err := sourceRepoCandidates(pkg, pkgInfo, hosts)
+	if err != nil {
+		return nil, err
+	}
+	return bestRepo(pkg, homePage, candidates)
+}
+
+// Returns the candidate source repositories of a package from its PKG-INFO and the hardcoded URLs, and its Home-page. It is an error only if the
+// metadata can't be parsed and no URL is hardcoded for the package.
+func sourceRepoCandidates(pkg string, pkgInfo []byte, hosts []*RepoHost) (candidates []*RepoCandidate, homePage string, err error) {
+	var evidence []*RepoEvidence
+	m, err := ParseMetadata(pkgInfo)
+	if err == nil {
+		evidence, homePage = metadataRepoEvidence(pkg, m, hosts), m.HomePage
+	}
+	evidence = append(evidence, hardcodedRepoEvidence(pkg)...)
+	if err != nil && len(evidence) == 0 {
+		return nil, "", fmt.Errorf("Could not read metadata of %s: %w", pkg, err)
+	}
+	return rankRepoCandidates(evidence, hosts), homePage, nil
+}
+
+// Returns the URL of the best candidate as FetchSourceRepoURL does: the repository of the strongest link to it, or the hardcoded URL as is.
+func bestRepoURL(pkg, homePage string, candidates []*RepoCandidate, hosts []*RepoHost) (string, error) {
+	if len(candidates) == 0 {
+		return "", &NoRepoError{Package: pkg, HomePage: homePage}
+	}
+	best := candidates[0].Evidence[0]
+	if best.Kind == HardcodedEvidence {
+		return best.URL, nil
+	}
+	return matchRepoURL(best.URL, hosts), nil
+}
+
+func bestRepo(pkg, homePage string, candidates []*RepoCandidate) (*RepoRef, error) {
+	if len(candidates) == 0 {
+		return nil, &NoRepoError{Package: pkg, HomePage: homePage}
+	}
+	return candidates[0].Repo, nil
+}
+
+// Returns the links in the metadata that point into a repository, in the order FetchSourceRepoURL prefers them.
+func metadataRepoEvidence(pkg string, m *Metadata, hosts []*RepoHost) []*RepoEvidence {
+	var evidence []*RepoEvidence
+	add := func(kind EvidenceKind, label, link string) {
+		if parseRepoLink(link, hosts) != nil {
+			evidence = append(evidence, &RepoEvidence{Kind: kind, Label: label, URL: strings.TrimSpace(link), Confidence: evidenceConfidence[kind]})
 		}
 	}
-	rawMetadata := string(b)
 
-	// Check PyPI
-	for _, pattern := range repoPatterns {
-		if match := pattern.FindStringSubmatch(rawMetadata); len(match) >= 1 {
-			return match[1], nil
+	used := make(map[*ProjectURL]bool)
+	for _, label := range sourceURLLabels {
+		for _, u := range m.ProjectURLs {
+			if !used[u] && normalizeURLLabel(u.Label) == label {
+				add(SourceURLEvidence, u.Label, u.URL)
+				used[u] = true
+			}
+		}
+	}
+	add(HomePageEvidence, "", m.HomePage)
+	add(DownloadURLEvidence, "", m.DownloadURL)
+	for _, u := range m.ProjectURLs {
+		if !used[u] {
+			add(ProjectURLEvidence, u.Label, u.URL)
 		}
 	}
 
-	// Try to fall back to hard-coded URLs
+	// Links in the description to a repository named after the package come first
+	var named, others []*RepoEvidence
+	for _, link := range textURLRegexp.FindAllString(m.Description, -1) {
+		link = strings.TrimRight(link, ".,;:!*_")
+		repoURL := matchRepoURL(link, hosts)
+		if repoURL == "" {
+			continue
+		}
+		e := &RepoEvidence{Kind: DescriptionEvidence, URL: link, Confidence: evidenceConfidence[DescriptionEvidence]}
+		if canonicalName(strings.TrimSuffix(path.Base(repoURL), ".git")) == canonicalName(pkg) {
+			named = append(named, e)
+		} else {
+			e.Confidence /= 2
+			others = append(others, e)
+		}
+	}
+	return append(append(evidence, named...), others...)
+}
+
+func hardcodedRepoEvidence(pkg string) []*RepoEvidence {
 	if hardURL, in := pypiRepos[NormalizedPkgName(pkg)]; in {
-		return hardURL, nil
+		return []*RepoEvidence{{Kind: HardcodedEvidence, URL: hardURL, Confidence: evidenceConfidence[HardcodedEvidence]}}
+	}
+	return nil
+}
+
+// Groups links by the repository they point into, and ranks the repositories by confidence. The confidence of a repository is the chance that
+// not all of the links to it are wrong, counting the strongest link of each kind only. Ties keep the order of the links.
+func rankRepoCandidates(evidence []*RepoEvidence, hosts []*RepoHost) []*RepoCandidate {
+	var candidates []*RepoCandidate
+	byRepo := make(map[string]*RepoCandidate)
+	for _, e := range evidence {
+		ref, err := ParseRepoRefWithHosts(e.URL, hosts)
+		if err != nil {
+			continue
+		}
+		// Hosts treat the owner and name case-insensitively more often than not
+		key := strings.ToLower(ref.Host + "/" + ref.Owner + "/" + ref.Name)
+		c, in := byRepo[key]
+		if !in {
+			c = &RepoCandidate{Repo: ref}
+			byRepo[key] = c
+			candidates = append(candidates, c)
+		}
+		c.Evidence = append(c.Evidence, e)
 	}
 
-	// Return most informative error
-	if match := homepageRegexp.FindStringSubmatch(rawMetadata); len(match) >= 1 {
-		return "", fmt.Errorf("Could not parse repo URL from homepage: %s", match[1])
+	for _, c := range candidates {
+		sort.SliceStable(c.Evidence, func(i, j int) bool { return c.Evidence[i].Confidence > c.Evidence[j].Confidence })
+		if ref, err := ParseRepoRefWithHosts(c.Evidence[0].URL, hosts); err == nil {
+			c.Repo = ref
+		}
+		doubt := 1.0
+		counted := make(map[EvidenceKind]bool)
+		for _, e := range c.Evidence {
+			if !counted[e.Kind] {
+				doubt *= 1 - e.Confidence
+				counted[e.Kind] = true
+			}
+		}
+		c.Confidence = 1 - doubt
 	}
-	return "", fmt.Errorf("No homepage found in metadata: %s", rawMetadata)
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Confidence > candidates[j].Confidence })
+	return candidates
 }
 
 var pypiRepos = map[string]string{
@@ -58,9 +319,9 @@ var pypiRepos = map[string]string{
 	"bottle":                "git://github.com/defnull/bottle",
 	"celery":                "git://github.com/celery/celery",
 	"chameleon":             "git://github.com/malthe/chameleon",
-	"coverage":              "https://bitbucket.org/ned/coveragepy",
+	"coverage":              "hg+https://bitbucket.org/ned/coveragepy",
 	"dependency_injection":  "git://github.com/gittip/dependency_injection.py",
-	"distribute":            "https://bitbucket.org/tarek/distribute",
+	"distribute":            "hg+https://bitbucket.org/tarek/distribute",
 	"django":                "git://github.com/django/django",
 	"django-cms":            "git://github.com/divio/django-cms",
 	"django-tastypie":       "git://github.com/toastdriven/django-tastypie",
@@ -92,9 +353,9 @@ var pypiRepos = map[string]string{
 	"nova":                  "git://github.com/openstack/nova",
 	"numpy":                 "git://github.com/numpy/numpy",
 	"pandas":                "git://github.com/pydata/pandas",
-	"pastedeploy":           "https://bitbucket.org/ianb/pastedeploy",
+	"pastedeploy":           "hg+https://bitbucket.org/ianb/pastedeploy",
 	"pattern":               "git://github.com/clips/pattern",
-	"postgres":              "git://github.com:gittip/postgres.py",
+	"postgres":              "git://github.com/gittip/postgres.py",
 	"psycopg2":              "git://github.com/psycopg/psycopg2",
 	"pyramid":               "git://github.com/Pylons/pyramid",
 	"python-catcher":        "git://github.com/Eugeny/catcher",
@@ -110,7 +371,7 @@ var pypiRepos = map[string]string{
 	"sentry":                "git://github.com/getsentry/sentry",
 	"setuptools":            "git://github.com/jaraco/setuptools",
 	"sockjs-tornado":        "git://github.com/mrjoes/sockjs-tornado",
-	"south":                 "https://bitbucket.org/andrewgodwin/south",
+	"south":                 "hg+https://bitbucket.org/andrewgodwin/south",
 	"sqlalchemy":            "git://github.com/zzzeek/sqlalchemy",
 	"ssh":                   "git://github.com/bitprophet/ssh",
 	"tornado":               "git://github.com/facebook/tornado",
@@ -1,20 +1,44 @@
 package cheerio
 
 import (
+	"errors"
+	"math"
 	"testing"
+
+	"github.com/beyang/cheerio/pypitest"
 )
 
 func TestFetchSourceRepoURL(t *testing.T) {
+	server := pypitest.NewServer(
+		&pypitest.Project{Name: "flask_cm", Releases: []*pypitest.Release{{
+			Version: "0.2.0",
+			PKGInfo: "Metadata-Version: 1.0\nName: flask_cm\nVersion: 0.2.0\nHome-page: https://github.com/futuregrid/flask_cm\n",
+		}}},
+		&pypitest.Project{Name: "zipaccess", Releases: []*pypitest.Release{{
+			Version: "0.2",
+			PKGInfo: "Metadata-Version: 1.0\nName: zipaccess\nVersion: 0.2\nHome-page: https://github.com/iki/zipaccess/tree/master\n",
+			Formats: []pypitest.Format{pypitest.Zip},
+		}}},
+		&pypitest.Project{Name: "flask", Releases: []*pypitest.Release{{
+			Version: "0.10.1",
+			PKGInfo: "Metadata-Version: 1.0\nName: Flask\nVersion: 0.10.1\nHome-page: http://flask.pocoo.org/\n",
+			Formats: []pypitest.Format{pypitest.Egg},
+		}}},
+	)
+	defer server.Close()
+	index := &PackageIndex{URI: server.URL}
+
 	tests := []struct {
 		pkg         string
 		wantRepoURL string
 	}{
 		{"flask_cm", "https://github.com/futuregrid/flask_cm"},
 		{"zipaccess", "https://github.com/iki/zipaccess"},
+		{"flask", "git://github.com/mitsuhiko/flask"}, // hard-coded
 	}
 
 	for _, test := range tests {
-		repoURL, err := DefaultPyPI.FetchSourceRepoURL(test.pkg)
+		repoURL, err := index.FetchSourceRepoURL(test.pkg)
 		if err != nil {
 			t.Error("FetchSourceRepoURL error:", err)
 			continue
@@ -24,3 +48,133 @@ func TestFetchSourceRepoURL(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceRepoURL(t *testing.T) {
+	const header = "Metadata-Version: 2.1\nName: foo-bar\nVersion: 1.0\n"
+	tests := []struct {
+		name        string
+		pkgInfo     string
+		wantRepoURL string
+	}{
+		{"source label first", header +
+			"Home-page: https://github.com/someone/fork\n" +
+			"Project-URL: Documentation, https://github.com/foo/docs\n" +
+			"Project-URL: Source Code, https://github.com/foo/foo-bar/\n",
+			"https://github.com/foo/foo-bar"},
+		{"source before repository", header +
+			"Project-URL: Repository, https://github.com/foo/repository\n" +
+			"Project-URL: Source, https://github.com/foo/source\n",
+			"https://github.com/foo/source"},
+		{"home page before download URL", header +
+			"Home-page: https://bitbucket.org/foo/foo-bar/src\n" +
+			"Download-URL: git+https://github.com/foo/foo-bar.git\n",
+			"https://bitbucket.org/foo/foo-bar"},
+		{"download URL", header +
+			"Home-page: https://foo-bar.readthedocs.io\n" +
+			"Download-URL: git+https://github.com/foo/foo-bar.git@v1.0\n",
+			"https://github.com/foo/foo-bar"},
+		{"other project URL", header +
+			"Home-page: https://foo-bar.readthedocs.io\n" +
+			"Project-URL: Bug Tracker, https://github.com/foo/foo-bar/issues\n",
+			"https://github.com/foo/foo-bar"},
+		{"description", header +
+			"Home-page: https://foo-bar.readthedocs.io\n\n" +
+			"Like [baz](https://github.com/baz/baz), but see `the code <https://github.com/foo/Foo_Bar>`_.\n" +
+			"Sponsor us at https://github.com/sponsors/foo.\n",
+			"https://github.com/foo/Foo_Bar"},
+		{"first description link", header +
+			"Description: Built on https://github.com/baz/baz.\n" +
+			"        Thanks!\n",
+			"https://github.com/baz/baz"},
+		{"malformed header", header +
+			"Home-page: https://github.com/foo/foo-bar\n" +
+			"A description with no blank line before it\n",
+			"https://github.com/foo/foo-bar"},
+	}
+	for _, test := range tests {
+		repoURL, err := sourceRepoURL("foo-bar", []byte(test.pkgInfo), DefaultRepoHosts)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if repoURL != test.wantRepoURL {
+			t.Errorf("%s: want %q, got %q", test.name, test.wantRepoURL, repoURL)
+		}
+	}
+
+	_, err := sourceRepoURL("foo-bar", []byte(header+"Home-page: https://foo-bar.readthedocs.io\n"), DefaultRepoHosts)
+	var noRepo *NoRepoError
+	if !errors.As(err, &noRepo) || noRepo.HomePage != "https://foo-bar.readthedocs.io" {
+		t.Errorf("want *NoRepoError for metadata without a repo URL, got %v", err)
+	}
+}
+
+func TestSourceRepoCandidates(t *testing.T) {
+	const header = "Metadata-Version: 2.1\nName: foo-bar\nVersion: 1.0\n"
+	pkgInfo := header +
+		"Home-page: https://github.com/someone/fork\n" +
+		"Download-URL: https://github.com/foo/foo-bar/archive/v1.0.tar.gz\n" +
+		"Project-URL: Bug Tracker, https://github.com/Foo/Foo-Bar/issues\n" +
+		"\n" +
+		"[![build](https://github.com/foo/foo-bar/workflows/ci/badge.svg)](https://github.com/foo/foo-bar/actions)\n" +
+		"Built on https://github.com/baz/baz.\n"
+	candidates, _, err := sourceRepoCandidates("foo-bar", []byte(pkgInfo), DefaultRepoHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		webURL     string
+		confidence float64
+		evidence   []EvidenceKind
+	}
+	wants := []want{
+		// The links agree on foo/foo-bar, which outweighs the Home-page, and the badges count once
+		{"https://github.com/foo/foo-bar", 1 - 0.25*0.4*0.5,
+			[]EvidenceKind{DownloadURLEvidence, ProjectURLEvidence, DescriptionEvidence, DescriptionEvidence}},
+		{"https://github.com/someone/fork", 0.85, []EvidenceKind{HomePageEvidence}},
+		{"https://github.com/baz/baz", 0.25, []EvidenceKind{DescriptionEvidence}},
+	}
+	if len(candidates) != len(wants) {
+		t.Fatalf("want %d candidates, got %d", len(wants), len(candidates))
+	}
+	for i, w := range wants {
+		c := candidates[i]
+		if c.Repo.WebURL != w.webURL || math.Abs(c.Confidence-w.confidence) > 1e-9 {
+			t.Errorf("%d: want %s (%.3f), got %s (%.3f)", i, w.webURL, w.confidence, c.Repo, c.Confidence)
+		}
+		var kinds []EvidenceKind
+		for _, e := range c.Evidence {
+			kinds = append(kinds, e.Kind)
+		}
+		if len(kinds) != len(w.evidence) {
+			t.Errorf("%d: want evidence %v, got %v", i, w.evidence, kinds)
+			continue
+		}
+		for j := range kinds {
+			if kinds[j] != w.evidence[j] {
+				t.Errorf("%d: want evidence %v, got %v", i, w.evidence, kinds)
+				break
+			}
+		}
+	}
+	if label := candidates[0].Evidence[1].Label; label != "Bug Tracker" {
+		t.Errorf("want the label of the Project-URL, got %q", label)
+	}
+	// The best candidate is the one FetchSourceRepoURL returns
+	if repoURL, err := sourceRepoURL("foo-bar", []byte(pkgInfo), DefaultRepoHosts); err != nil || repoURL != "https://github.com/foo/foo-bar" {
+		t.Errorf("want https://github.com/foo/foo-bar, got %q (error: %v)", repoURL, err)
+	}
+
+	// A hardcoded URL outranks links in the description, and isn't an error without metadata links
+	candidates, _, err = sourceRepoCandidates("flask", []byte("Metadata-Version: 1.0\nName: Flask\nVersion: 0.10.1\n"+
+		"Description: Like https://github.com/bottlepy/bottle\n"), DefaultRepoHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || candidates[0].Evidence[0].Kind != HardcodedEvidence {
+		t.Errorf("want the hardcoded repo first, got %+v", candidates)
+	}
+	candidates, _, err = sourceRepoCandidates("foo-bar", []byte(header), DefaultRepoHosts)
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("want no candidates and no error, got %+v (error: %v)", candidates, err)
+	}
+}
@@ -1,8 +1,13 @@
 package cheerio
 
 import (
+	"path"
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/beyang/cheerio/fetch"
+	"github.com/beyang/go-version"
 )
 
 // Normalizes package names so they are comparable
@@ -10,34 +15,193 @@ func NormalizedPkgName(pkg string) string {
 	return strings.ToLower(pkg)
 }
 
+var canonicalNameRegexp = regexp.MustCompile(`[\-_\.]+`)
+
+// Normalizes package names as described in PEP 503, so that, e.g., "Foo.Bar" and "foo_bar" compare equal.
+func canonicalName(pkg string) string {
+	return canonicalNameRegexp.ReplaceAllString(strings.ToLower(pkg), "-")
+}
+
 // Convenience functions that get the last instance of a type of file
-var tarRegexp = regexp.MustCompile(`[/A-Za-z0-9\._\-]+\.(?:tar\.(?:gz|bz2)|tgz)`)
+var tarRegexp = regexp.MustCompile(`[/A-Za-z0-9\._\-]+\.(?:tar(?:\.(?:gz|bz2|xz|zst))?|tgz|tbz)$`)
 var zipRegexp = regexp.MustCompile(`[/A-Za-z0-9\._\-]+\.zip`)
 var eggRegexp = regexp.MustCompile(`[/A-Za-z0-9\._\-]+\.egg`)
 
-func lastTar(files []string) string {
+func lastTar(files []*DistFile) *DistFile {
 	for f := len(files) - 1; f >= 0; f-- {
-		if tarRegexp.MatchString(files[f]) {
+		if tarRegexp.MatchString(files[f].Filename) {
 			return files[f]
 		}
 	}
-	return ""
+	return nil
 }
 
-func lastEgg(files []string) string {
+func lastEgg(files []*DistFile) *DistFile {
 	for f := len(files) - 1; f >= 0; f-- {
-		if eggRegexp.MatchString(files[f]) {
+		if eggRegexp.MatchString(files[f].Filename) {
 			return files[f]
 		}
 	}
-	return ""
+	return nil
 }
 
-func lastZip(files []string) string {
+func lastZip(files []*DistFile) *DistFile {
 	for f := len(files) - 1; f >= 0; f-- {
-		if zipRegexp.MatchString(files[f]) {
+		if zipRegexp.MatchString(files[f].Filename) {
 			return files[f]
 		}
 	}
+	return nil
+}
+
+var distExtRegexp = regexp.MustCompile(`\.(?:tar\.gz|tar\.bz2|tar\.xz|tar\.zst|tgz|tbz|tar|zip|egg|whl|exe|msi|rpm|dmg)$`)
+
+// Extracts the version from a distribution filename, e.g., "1.0" from "Foo_Bar-1.0.tar.gz". Wheel and egg filenames escape dashes in the
+// project name, so the version is always their second component. Sdist names may contain unescaped dashes, so the prefix matching the package
+// name is stripped instead.
+func versionFromFilename(pkg, filename string) string {
+	base := distExtRegexp.ReplaceAllString(filename, "")
+	if base == filename {
+		return ""
+	}
+	if strings.HasSuffix(filename, ".whl") || strings.HasSuffix(filename, ".egg") {
+		if parts := strings.Split(base, "-"); len(parts) >= 2 {
+			return parts[1]
+		}
+		return ""
+	}
+	for i := strings.Index(base, "-"); i >= 0 && i < len(base); {
+		if canonicalName(base[:i]) == canonicalName(pkg) {
+			return base[i+1:]
+		}
+		next := strings.Index(base[i+1:], "-")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	if i := strings.LastIndex(base, "-"); i >= 0 {
+		return base[i+1:]
+	}
 	return ""
 }
+
+// Sorts files in ascending version order. Files with the same version keep their relative order.
+func sortDistFiles(files []*DistFile) {
+	var versions []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !seen[file.Version] {
+			seen[file.Version] = true
+			versions = append(versions, file.Version)
+		}
+	}
+	version.Sort(versions)
+
+	sorted := make([]*DistFile, 0, len(files))
+	for _, v := range versions {
+		for _, file := range files {
+			if file.Version == v {
+				sorted = append(sorted, file)
+			}
+		}
+	}
+	copy(files, sorted)
+}
+
+// Reports whether version a sorts before version b.
+func versionLess(a, b string) bool {
+	if a == b {
+		return false
+	}
+	versions := []string{b, a}
+	version.Sort(versions)
+	return versions[0] == a
+}
+
+// Reports whether a version satisfies the requirement's constraint. A requirement without a constraint is satisfied by any version.
+func (r *Requirement) satisfiedBy(v string) bool {
+	switch r.Constraint {
+	case "==":
+		return v == r.Version
+	case ">=":
+		return !versionLess(v, r.Version)
+	case ">":
+		return versionLess(r.Version, v)
+	case "<=":
+		return !versionLess(r.Version, v)
+	case "<":
+		return versionLess(v, r.Version)
+	}
+	return true
+}
+
+// Returns the contents of the files, concatenated in path order.
+func concatFiles(files fetch.Files) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, name := range names {
+		b = append(b, files[name]...)
+	}
+	return b
+}
+
+// Returns the files whose paths match pattern.
+func matchingFiles(files fetch.Files, pattern *regexp.Regexp) fetch.Files {
+	matching := make(fetch.Files)
+	for name, data := range files {
+		if pattern.MatchString(name) {
+			matching[name] = data
+		}
+	}
+	return matching
+}
+
+// Chooses, from metadata files extracted from a package's archive, the one that belongs to the package itself rather than to a package
+// vendored in it: one in an egg-info directory named after the package or at the root of the archive (see ownMetadataFile), and among those,
+// the one nearest the root. So in a src layout, foo-1.0/src/foo.egg-info/ is chosen over a shallower foo-1.0/bar.egg-info/. Returns nil if
+// there are no files.
+func topLevelFile(pkg string, files fetch.Files) []byte {
+	var best string
+	found := false
+	for name := range files {
+		if !found || topLevelLess(pkg, name, best) {
+			best, found = name, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return files[best]
+}
+
+func topLevelLess(pkg, a, b string) bool {
+	if ownA, ownB := ownMetadataFile(pkg, a), ownMetadataFile(pkg, b); ownA != ownB {
+		return ownA
+	}
+	if depthA, depthB := strings.Count(a, "/"), strings.Count(b, "/"); depthA != depthB {
+		return depthA < depthB
+	}
+	return a < b
+}
+
+// Reports whether a metadata file surely belongs to the package: it is in the package's own egg-info directory, or at the root of the archive
+// (e.g., "foo-1.0/PKG-INFO" in an sdist or "EGG-INFO/requires.txt" in an egg).
+func ownMetadataFile(pkg, name string) bool {
+	return inOwnEggInfo(pkg, name) || strings.Count(name, "/") <= 1
+}
+
+// Reports whether a path is in an egg-info directory named after the package (e.g., "foo-1.0/foo.egg-info/requires.txt" for foo). Egg-info
+// directories may also carry a version ("foo-1.0.egg-info"); dashes in the name itself are escaped as underscores.
+func inOwnEggInfo(pkg, name string) bool {
+	dir := path.Base(path.Dir(name))
+	if !strings.HasSuffix(dir, ".egg-info") {
+		return false
+	}
+	project := strings.SplitN(strings.TrimSuffix(dir, ".egg-info"), "-", 2)[0]
+	return canonicalName(project) == canonicalName(pkg)
+}
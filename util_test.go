@@ -0,0 +1,34 @@
+package cheerio
+
+import (
+	"testing"
+
+	"github.com/beyang/cheerio/fetch"
+)
+
+func TestTopLevelFile(t *testing.T) {
+	tests := []struct {
+		pkg   string
+		files []string
+		want  string
+	}{
+		{"foo", []string{"foo-1.0/foo.egg-info/requires.txt", "foo-1.0/vendor/bar-2.0/bar.egg-info/requires.txt"}, "foo-1.0/foo.egg-info/requires.txt"},
+		{"foo", []string{"foo-1.0/foo.egg-info/PKG-INFO", "foo-1.0/PKG-INFO"}, "foo-1.0/PKG-INFO"},
+		{"foo-bar", []string{"foo-bar-1.0/aaa.egg-info/top_level.txt", "foo-bar-1.0/foo_bar.egg-info/top_level.txt"}, "foo-bar-1.0/foo_bar.egg-info/top_level.txt"},
+		{"foo", []string{"foo-1.0/src/foo.egg-info/requires.txt"}, "foo-1.0/src/foo.egg-info/requires.txt"},
+		{"foo", []string{"foo-1.0/bar.egg-info/requires.txt", "foo-1.0/src/foo.egg-info/requires.txt"}, "foo-1.0/src/foo.egg-info/requires.txt"},
+		{"foo", []string{"foo-1.0/src/foo.egg-info/PKG-INFO", "foo-1.0/bar.egg-info/PKG-INFO", "foo-1.0/PKG-INFO"}, "foo-1.0/PKG-INFO"},
+		{"foo", []string{"foo-1.0/vendor/bar.egg-info/requires.txt", "foo-1.0/baz.egg-info/requires.txt"}, "foo-1.0/baz.egg-info/requires.txt"},
+		{"foo", []string{"EGG-INFO/requires.txt"}, "EGG-INFO/requires.txt"},
+		{"foo", nil, ""},
+	}
+	for _, test := range tests {
+		files := make(fetch.Files)
+		for _, name := range test.files {
+			files[name] = []byte(name)
+		}
+		if got := string(topLevelFile(test.pkg, files)); got != test.want {
+			t.Errorf("%s %v: want %q, got %q", test.pkg, test.files, test.want, got)
+		}
+	}
+}